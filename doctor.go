@@ -0,0 +1,38 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "github.com/pwnall/hsreporter/reporter"
+  "os"
+)
+
+// doctorMain implements the doctor command.
+//
+// The command checks the setup that hsreporter needs to upload logs, and
+// explains how to fix the problems that it finds. It exits with status 1 if
+// it finds a problem that stops logs from being uploaded.
+func doctorMain(args []string) {
+  var config reporter.Config
+  flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+  addHearthstoneFlags(flags, &config)
+  addUploadFlags(flags, &config)
+  flags.Usage = func() {
+    fmt.Fprintf(flags.Output(), "Usage: %s doctor [flags]\n", os.Args[0])
+    flags.PrintDefaults()
+  }
+  flags.Parse(args)
+
+  exitStatus := 0
+  for _, finding := range reporter.Diagnose(config) {
+    fmt.Printf("%-8s %s: %s\n", finding.Level, finding.Subject,
+        finding.Message)
+    if finding.Fix != "" {
+      fmt.Printf("%-8s Fix: %s\n", "", finding.Fix)
+    }
+    if finding.Level == reporter.FindingProblem {
+      exitStatus = 1
+    }
+  }
+  os.Exit(exitStatus)
+}
@@ -0,0 +1,187 @@
+// Package game reconstructs the state of Hearthstone games from the game log.
+//
+// Hearthstone's Power logging category describes a game as a set of entities,
+// such as the game itself, the players, and the cards. Each entity has tags,
+// which are key=value pairs, such as ZONE=HAND. The log records the entities'
+// creation and every tag change. Tracker replays these changes into a Game,
+// and reports high-level events, such as the start of a turn.
+package game
+
+import (
+  "sort"
+  "strconv"
+)
+
+// The entity ID that Hearthstone uses for the game entity.
+const GameEntityId = 1
+
+// Game is the state of a Hearthstone game.
+type Game struct {
+  // The game's entities, by ID.
+  Entities map[int]*Entity
+  // The game's players, in the order in which they were created.
+  Players []*Player
+}
+
+// newGame creates an empty game.
+func newGame() *Game {
+  return &Game{Entities: make(map[int]*Entity)}
+}
+
+// GameEntity returns the entity that holds the game's tags.
+func (g *Game) GameEntity() *Entity {
+  return g.Entities[GameEntityId]
+}
+
+// Turn returns the current turn number. Turns are numbered from 1.
+func (g *Game) Turn() int {
+  if gameEntity := g.GameEntity(); gameEntity != nil {
+    return gameEntity.IntTag("TURN")
+  }
+  return 0
+}
+
+// Player returns the player with a PlayerID, or nil if there is no such
+// player.
+func (g *Game) Player(playerId int) *Player {
+  for _, player := range g.Players {
+    if player.PlayerId == playerId {
+      return player
+    }
+  }
+  return nil
+}
+
+// CurrentPlayer returns the player whose turn it is, or nil before the game
+// starts.
+func (g *Game) CurrentPlayer() *Player {
+  for _, player := range g.Players {
+    if player.Entity.IntTag("CURRENT_PLAYER") == 1 {
+      return player
+    }
+  }
+  return nil
+}
+
+// Hero returns a player's hero entity, or nil if the hero is not known yet.
+func (g *Game) Hero(player *Player) *Entity {
+  if heroId := player.Entity.IntTag("HERO_ENTITY"); heroId != 0 {
+    return g.Entities[heroId]
+  }
+  // NOTE: Some game versions don't set HERO_ENTITY on the players, so we look
+  //       for the hero card in the player's play zone.
+  for _, entity := range g.Zone(player.PlayerId, "PLAY") {
+    if entity.Tag("CARDTYPE") == "HERO" {
+      return entity
+    }
+  }
+  return nil
+}
+
+// Zone returns the entities controlled by a player in a zone, such as "HAND".
+//
+// The entities are sorted by their position in the zone.
+func (g *Game) Zone(playerId int, zone string) []*Entity {
+  var entities []*Entity
+  for _, entity := range g.Entities {
+    if entity.Controller() == playerId && entity.Zone() == zone {
+      entities = append(entities, entity)
+    }
+  }
+  sort.Slice(entities, func(i, j int) bool {
+    positionI := entities[i].IntTag("ZONE_POSITION")
+    positionJ := entities[j].IntTag("ZONE_POSITION")
+    if positionI != positionJ {
+      return positionI < positionJ
+    }
+    return entities[i].Id < entities[j].Id
+  })
+  return entities
+}
+
+// Winner returns the player who won the game, or nil if no player won yet.
+func (g *Game) Winner() *Player {
+  for _, player := range g.Players {
+    if player.Result() == "WON" {
+      return player
+    }
+  }
+  return nil
+}
+
+// Over returns true if the game ended.
+func (g *Game) Over() bool {
+  gameEntity := g.GameEntity()
+  return gameEntity != nil && gameEntity.Tag("STATE") == "COMPLETE"
+}
+
+// entity returns the entity with an ID, creating it if it doesn't exist.
+func (g *Game) entity(id int) *Entity {
+  entity := g.Entities[id]
+  if entity == nil {
+    entity = &Entity{Id: id, Tags: make(map[string]string)}
+    g.Entities[id] = entity
+  }
+  return entity
+}
+
+// Entity is a game object, such as a card, a player or the game itself.
+type Entity struct {
+  // The entity's ID, which is unique within the game.
+  Id int
+  // The card's ID, such as "EX1_116". Empty if the card is hidden, or if the
+  // entity is not a card.
+  CardId string
+  // The card's name, such as "Leeroy Jenkins". Empty if not known.
+  Name string
+  // The entity's tags, such as "ZONE" => "HAND".
+  Tags map[string]string
+}
+
+// Tag returns the value of a tag, or "" if the tag is not set.
+func (e *Entity) Tag(name string) string {
+  return e.Tags[name]
+}
+
+// IntTag returns the value of a numeric tag, or 0 if the tag is not set.
+func (e *Entity) IntTag(name string) int {
+  value, err := strconv.Atoi(e.Tags[name])
+  if err != nil {
+    return 0
+  }
+  return value
+}
+
+// Zone returns the zone that holds the entity, such as "HAND" or "PLAY".
+func (e *Entity) Zone() string {
+  return e.Tag("ZONE")
+}
+
+// Controller returns the PlayerID of the player who controls the entity.
+func (e *Entity) Controller() int {
+  return e.IntTag("CONTROLLER")
+}
+
+// Player is a participant in a game.
+type Player struct {
+  // The entity that holds the player's tags.
+  Entity *Entity
+  // The player's number in the game, which is used by the CONTROLLER tag.
+  PlayerId int
+  // The player's BattleTag, such as "Name#1234". Empty if not known yet.
+  Name string
+  // The hi and lo parts of the player's account ID.
+  AccountHi string
+  AccountLo string
+}
+
+// Result returns "WON", "LOST" or "TIED" after the game ends, and "" before.
+func (p *Player) Result() string {
+  switch playState := p.Entity.Tag("PLAYSTATE"); playState {
+  case "WON", "LOST", "TIED":
+    return playState
+  case "CONCEDED":
+    return "LOST"
+  }
+  return ""
+}
@@ -0,0 +1,345 @@
+package game
+
+import (
+  "github.com/pwnall/hsreporter/parser"
+  "strconv"
+  "strings"
+)
+
+// The method that writes the game's state changes to the Power log.
+//
+// PowerTaskList.DebugPrintPower repeats the same changes, as the client
+// animates them, so its lines are ignored.
+const powerMethod = "GameState.DebugPrintPower"
+
+// The method that writes the players' names to the Power log.
+const gameInfoMethod = "GameState.DebugPrintGame"
+
+// EventType identifies the kinds of high-level game events.
+type EventType int
+
+const (
+  // A game was created, and its players are known.
+  GameStart EventType = iota + 1
+  // A player's turn started. The mulligan is not a turn.
+  TurnStart
+  // A player played a card from their hand, or used their hero power.
+  CardPlayed
+  // The game ended.
+  GameEnd
+)
+
+// String returns the event type's name, such as "TurnStart".
+func (t EventType) String() string {
+  switch t {
+  case GameStart:
+    return "GameStart"
+  case TurnStart:
+    return "TurnStart"
+  case CardPlayed:
+    return "CardPlayed"
+  case GameEnd:
+    return "GameEnd"
+  }
+  return "EventType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Event is a high-level change in a game.
+type Event struct {
+  Type EventType
+  // The game where the event happened.
+  Game *Game
+  // The turn when the event happened. 0 during the mulligan.
+  Turn int
+  // For TurnStart, the player whose turn started. For CardPlayed, the player
+  // who played the card. For GameEnd, the winner, or nil if the game ended in
+  // a tie.
+  Player *Player
+  // For CardPlayed, the card that was played.
+  Card *Entity
+}
+
+// Tracker replays the Power log into a Game.
+//
+// The Tracker follows one game at a time. When a new game is created, the
+// previous game's state is discarded.
+type Tracker struct {
+  // The game being tracked. nil before the first game is created.
+  game *Game
+  // The entity whose tags are listed by the following lines.
+  current *Entity
+  // The blocks that were started but didn't end yet, innermost last.
+  blocks []block
+  // True if GameStart will be reported when the game's creation is done.
+  startPending bool
+}
+
+// block is a group of changes caused by a single action, such as playing a
+// card.
+type block struct {
+  // The kind of action, such as "PLAY" or "ATTACK".
+  blockType string
+  // The entity that caused the action.
+  entity *Entity
+}
+
+// Game returns the game being tracked, or nil if no game was created yet.
+func (t *Tracker) Game() *Game {
+  return t.game
+}
+
+// ProcessLine updates the game's state with a line from the game log.
+//
+// It returns the high-level events caused by the line. Lines that don't
+// describe game state changes are ignored.
+func (t *Tracker) ProcessLine(data []byte) []Event {
+  return t.Process(parser.ParseBytes(data))
+}
+
+// Process updates the game's state with a parsed line from the game log.
+//
+// It returns the high-level events caused by the line.
+func (t *Tracker) Process(line parser.Line) []Event {
+  if line.Category != "" && line.Category != "Power" {
+    return nil
+  }
+  if line.Method == gameInfoMethod {
+    t.processGameInfo(line)
+    return nil
+  }
+  if line.Method != powerMethod {
+    return nil
+  }
+
+  command := line.Text
+  if space := strings.IndexByte(command, ' '); space != -1 {
+    command = command[:space]
+  }
+  if command == "CREATE_GAME" {
+    t.game = newGame()
+    t.current = nil
+    t.blocks = nil
+    t.startPending = true
+    return nil
+  }
+  if t.game == nil {
+    // The reporter started watching the log in the middle of a game.
+    return nil
+  }
+
+  var events []Event
+  if t.startPending && line.Depth == 0 {
+    t.startPending = false
+    events = append(events, t.newEvent(GameStart))
+  }
+
+  switch command {
+  case "":
+    if tag, ok := line.Get("tag"); ok && t.current != nil {
+      events = append(events, t.changeTag(t.current, tag,
+          line.Value("value"))...)
+    }
+  case "GameEntity":
+    t.current = t.game.entity(atoi(line.Value("EntityID")))
+  case "Player":
+    t.current = t.game.entity(atoi(line.Value("EntityID")))
+    player := &Player{
+      Entity: t.current,
+      PlayerId: atoi(line.Value("PlayerID")),
+    }
+    if accountFields, ok := parser.ParseBracketed(
+        line.Value("GameAccountId")); ok {
+      accountLine := parser.Line{Fields: accountFields}
+      player.AccountHi = accountLine.Value("hi")
+      player.AccountLo = accountLine.Value("lo")
+    }
+    t.game.Players = append(t.game.Players, player)
+  case "FULL_ENTITY":
+    // Older game versions write "FULL_ENTITY - Creating ID=4 CardID=X", while
+    // newer versions write "FULL_ENTITY - Updating [id=4 ...] CardID=X".
+    // The description may contain spaces, as in "[entityName=UNKNOWN ENTITY
+    // [cardType=INVALID] id=4 ...]", so it starts at the first bracket.
+    reference, ok := line.Get("ID")
+    if !ok {
+      if start := strings.IndexByte(line.Text, '['); start != -1 {
+        reference = line.Text[start:]
+      } else {
+        reference = line.Text[strings.LastIndex(line.Text, " ") + 1:]
+      }
+    }
+    t.current = t.resolveEntity(reference)
+    t.updateCardId(t.current, line.Value("CardID"))
+  case "SHOW_ENTITY", "CHANGE_ENTITY":
+    t.current = t.resolveEntity(line.Value("Entity"))
+    t.updateCardId(t.current, line.Value("CardID"))
+  case "HIDE_ENTITY":
+    t.current = nil
+    if entity := t.resolveEntity(line.Value("Entity")); entity != nil {
+      events = append(events, t.changeTag(entity, line.Value("tag"),
+          line.Value("value"))...)
+    }
+  case "TAG_CHANGE":
+    t.current = nil
+    reference := line.Value("Entity")
+    tag, value := line.Value("tag"), line.Value("value")
+    if tag == "PLAYER_ID" {
+      t.namePlayer(reference, atoi(value))
+    }
+    if entity := t.resolveEntity(reference); entity != nil {
+      events = append(events, t.changeTag(entity, tag, value)...)
+    }
+  case "BLOCK_START":
+    t.current = nil
+    t.blocks = append(t.blocks, block{
+      blockType: line.Value("BlockType"),
+      entity: t.resolveEntity(line.Value("Entity")),
+    })
+  case "BLOCK_END":
+    t.current = nil
+    if len(t.blocks) == 0 {
+      break
+    }
+    ended := t.blocks[len(t.blocks) - 1]
+    t.blocks = t.blocks[:len(t.blocks) - 1]
+    // NOTE: The event is reported when the block ends, because the card
+    //       played by the opponent is only revealed inside the block.
+    if ended.blockType == "PLAY" && ended.entity != nil {
+      event := t.newEvent(CardPlayed)
+      event.Player = t.game.Player(ended.entity.Controller())
+      event.Card = ended.entity
+      events = append(events, event)
+    }
+  default:
+    t.current = nil
+  }
+  return events
+}
+
+// processGameInfo handles the lines that list the players' names.
+//
+// The lines look like "PlayerID=1, PlayerName=Name#1234".
+func (t *Tracker) processGameInfo(line parser.Line) {
+  if t.game == nil {
+    return
+  }
+  playerId := atoi(strings.TrimSuffix(line.Value("PlayerID"), ","))
+  if player := t.game.Player(playerId); player != nil {
+    player.Name = line.Value("PlayerName")
+  }
+}
+
+// changeTag sets an entity's tag, and returns the resulting events.
+func (t *Tracker) changeTag(entity *Entity, tag string, value string) []Event {
+  if tag == "" {
+    return nil
+  }
+  oldValue := entity.Tags[tag]
+  entity.Tags[tag] = value
+  if entity.Id != GameEntityId || oldValue == value {
+    return nil
+  }
+
+  switch {
+  case tag == "STEP" && value == "MAIN_READY":
+    event := t.newEvent(TurnStart)
+    event.Player = t.game.CurrentPlayer()
+    return []Event{event}
+  case tag == "STATE" && value == "COMPLETE":
+    event := t.newEvent(GameEnd)
+    event.Player = t.game.Winner()
+    return []Event{event}
+  }
+  return nil
+}
+
+// newEvent creates an event of the given type for the current game.
+func (t *Tracker) newEvent(eventType EventType) Event {
+  return Event{Type: eventType, Game: t.game, Turn: t.game.Turn()}
+}
+
+// resolveEntity finds the entity that a line refers to.
+//
+// References can be entity IDs, "GameEntity", player names, or entity
+// descriptions, such as "[entityName=Leeroy Jenkins id=21 ...]". It returns
+// nil if the reference can't be resolved.
+func (t *Tracker) resolveEntity(reference string) *Entity {
+  if reference == "" {
+    return nil
+  }
+  if reference == "GameEntity" {
+    return t.game.entity(GameEntityId)
+  }
+  if id, err := strconv.Atoi(reference); err == nil {
+    return t.game.entity(id)
+  }
+  if fields, ok := parser.ParseBracketed(reference); ok {
+    description := parser.Line{Fields: fields}
+    id := atoi(description.Value("id"))
+    if id == 0 {
+      return nil
+    }
+    entity := t.game.entity(id)
+    name := description.Value("entityName")
+    if name != "" && !strings.HasPrefix(name, "UNKNOWN ENTITY") {
+      entity.Name = name
+    }
+    t.updateCardId(entity, description.Value("cardId"))
+    return entity
+  }
+  if player := t.playerByName(reference); player != nil {
+    return player.Entity
+  }
+  return nil
+}
+
+// playerByName finds the player with a name.
+//
+// If no player has the name, and only one player's name is unknown, the name
+// must belong to that player. Names can't contain = or [, so entity
+// descriptions that failed to parse are never mistaken for names.
+func (t *Tracker) playerByName(name string) *Player {
+  if strings.ContainsAny(name, "=[") {
+    return nil
+  }
+  var unnamed []*Player
+  for _, player := range t.game.Players {
+    if player.Name == name {
+      return player
+    }
+    if player.Name == "" {
+      unnamed = append(unnamed, player)
+    }
+  }
+  if len(unnamed) == 1 {
+    unnamed[0].Name = name
+    return unnamed[0]
+  }
+  return nil
+}
+
+// namePlayer records a player's name, learned from a PLAYER_ID tag change.
+func (t *Tracker) namePlayer(name string, playerId int) {
+  if _, err := strconv.Atoi(name); err == nil ||
+      strings.HasPrefix(name, "[") || name == "GameEntity" {
+    return
+  }
+  if player := t.game.Player(playerId); player != nil && player.Name == "" {
+    player.Name = name
+  }
+}
+
+// updateCardId records an entity's card ID, if it is revealed.
+func (t *Tracker) updateCardId(entity *Entity, cardId string) {
+  if entity != nil && cardId != "" {
+    entity.CardId = cardId
+  }
+}
+
+// atoi parses a number, returning 0 if the text isn't a number.
+func atoi(text string) int {
+  value, err := strconv.Atoi(text)
+  if err != nil {
+    return 0
+  }
+  return value
+}
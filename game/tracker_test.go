@@ -0,0 +1,221 @@
+package game
+
+import (
+  "reflect"
+  "testing"
+)
+
+// The prefixes that the single-file game log and the per-category Power.log
+// write before the Power log's payloads.
+var testLogFormats = []struct {
+  name string
+  powerPrefix string
+  gamePrefix string
+}{
+  {
+    name: "single-file",
+    powerPrefix: "[Power] GameState.DebugPrintPower() - ",
+    gamePrefix: "[Power] GameState.DebugPrintGame() - ",
+  },
+  {
+    name: "per-category",
+    powerPrefix: "D 20:15:01.2345678 GameState.DebugPrintPower() - ",
+    gamePrefix: "D 20:15:01.2345678 GameState.DebugPrintGame() - ",
+  },
+}
+
+// A short game, as written by GameState.DebugPrintPower. Lines that start
+// with "# " are written by GameState.DebugPrintGame.
+var testGameLines = []string{
+  "CREATE_GAME",
+  "    GameEntity EntityID=1",
+  "        tag=TURN value=0",
+  "        tag=ZONE value=PLAY",
+  "    Player EntityID=2 PlayerID=1 GameAccountId=[hi=144115193835963207 " +
+      "lo=30722021]",
+  "        tag=PLAYER_ID value=1",
+  "        tag=CONTROLLER value=1",
+  "    Player EntityID=3 PlayerID=2 GameAccountId=[hi=144115193835963207 " +
+      "lo=41562011]",
+  "        tag=PLAYER_ID value=2",
+  "        tag=CONTROLLER value=2",
+  "    FULL_ENTITY - Creating ID=4 CardID=EX1_116",
+  "        tag=ZONE value=HAND",
+  "        tag=CONTROLLER value=1",
+  "    FULL_ENTITY - Creating ID=5 CardID=",
+  "        tag=ZONE value=DECK",
+  "        tag=CONTROLLER value=2",
+  "# PlayerID=1, PlayerName=Alice#1234",
+  "TAG_CHANGE Entity=GameEntity tag=STATE value=RUNNING",
+  "TAG_CHANGE Entity=GameEntity tag=TURN value=1",
+  "TAG_CHANGE Entity=Alice#1234 tag=CURRENT_PLAYER value=1",
+  "TAG_CHANGE Entity=GameEntity tag=STEP value=MAIN_READY",
+  "BLOCK_START BlockType=PLAY Entity=[entityName=Leeroy Jenkins id=4 " +
+      "zone=HAND zonePos=1 cardId=EX1_116 player=1] EffectCardId= " +
+      "EffectIndex=0 Target=0",
+  "    TAG_CHANGE Entity=[entityName=Leeroy Jenkins id=4 zone=HAND " +
+      "zonePos=1 cardId=EX1_116 player=1] tag=ZONE value=PLAY",
+  "BLOCK_END",
+  "TAG_CHANGE Entity=GameEntity tag=STEP value=MAIN_END",
+  "TAG_CHANGE Entity=Alice#1234 tag=CURRENT_PLAYER value=0",
+  "TAG_CHANGE Entity=Bob#5678 tag=CURRENT_PLAYER value=1",
+  "TAG_CHANGE Entity=GameEntity tag=TURN value=2",
+  "TAG_CHANGE Entity=GameEntity tag=STEP value=MAIN_READY",
+  "TAG_CHANGE Entity=Bob#5678 tag=PLAYSTATE value=CONCEDED",
+  "TAG_CHANGE Entity=Alice#1234 tag=PLAYSTATE value=WON",
+  "TAG_CHANGE Entity=GameEntity tag=STATE value=COMPLETE",
+}
+
+// testEvent summarizes an Event, so events can be compared.
+type testEvent struct {
+  Type EventType
+  Turn int
+  // The name of the event's player, or "" if it has no player.
+  Player string
+  // The ID of the event's card, or "" if it has no card.
+  Card string
+}
+
+// processLines feeds lines to a Tracker, and summarizes the events.
+func processLines(tracker *Tracker, lines []string) []testEvent {
+  var events []testEvent
+  for _, line := range lines {
+    for _, event := range tracker.ProcessLine([]byte(line + "\n")) {
+      summary := testEvent{Type: event.Type, Turn: event.Turn}
+      if event.Player != nil {
+        summary.Player = event.Player.Name
+      }
+      if event.Card != nil {
+        summary.Card = event.Card.CardId
+      }
+      events = append(events, summary)
+    }
+  }
+  return events
+}
+
+// formatLines adds a log format's prefixes to lines from testGameLines.
+func formatLines(powerPrefix string, gamePrefix string,
+    lines []string) []string {
+  formatted := make([]string, len(lines))
+  for i, line := range lines {
+    if len(line) > 2 && line[:2] == "# " {
+      formatted[i] = gamePrefix + line[2:]
+    } else {
+      formatted[i] = powerPrefix + line
+    }
+  }
+  return formatted
+}
+
+func TestTrackerGame(t *testing.T) {
+  want := []testEvent{
+    {Type: GameStart},
+    {Type: TurnStart, Turn: 1, Player: "Alice#1234"},
+    {Type: CardPlayed, Turn: 1, Player: "Alice#1234", Card: "EX1_116"},
+    {Type: TurnStart, Turn: 2, Player: "Bob#5678"},
+    {Type: GameEnd, Turn: 2, Player: "Alice#1234"},
+  }
+  for _, format := range testLogFormats {
+    var tracker Tracker
+    lines := formatLines(format.powerPrefix, format.gamePrefix,
+        testGameLines)
+    events := processLines(&tracker, lines)
+    if !reflect.DeepEqual(events, want) {
+      t.Errorf("%s: got events %+v, want %+v", format.name, events, want)
+    }
+
+    game := tracker.Game()
+    if game == nil {
+      t.Fatalf("%s: no game was created", format.name)
+    }
+    if !game.Over() {
+      t.Errorf("%s: game is not over", format.name)
+    }
+    results := make(map[string]string)
+    for _, player := range game.Players {
+      results[player.Name] = player.Result()
+    }
+    wantResults := map[string]string{"Alice#1234": "WON", "Bob#5678": "LOST"}
+    if !reflect.DeepEqual(results, wantResults) {
+      t.Errorf("%s: got results %v, want %v", format.name, results,
+          wantResults)
+    }
+    if player := game.Player(1); player == nil ||
+        player.AccountLo != "30722021" {
+      t.Errorf("%s: player 1's account ID was not recorded", format.name)
+    }
+    if zone := game.Entities[4].Zone(); zone != "PLAY" {
+      t.Errorf("%s: the played card is in %s, want PLAY", format.name, zone)
+    }
+  }
+}
+
+func TestTrackerIgnoresGameInProgress(t *testing.T) {
+  var tracker Tracker
+  lines := formatLines(testLogFormats[0].powerPrefix,
+      testLogFormats[0].gamePrefix, testGameLines[17:])
+  if events := processLines(&tracker, lines); len(events) != 0 {
+    t.Errorf("got events %+v for a game without CREATE_GAME", events)
+  }
+  if tracker.Game() != nil {
+    t.Errorf("created a game without CREATE_GAME")
+  }
+}
+
+func TestTrackerIgnoresOtherCategories(t *testing.T) {
+  var tracker Tracker
+  lines := formatLines("[Zone] GameState.DebugPrintPower() - ",
+      "[Zone] GameState.DebugPrintGame() - ", testGameLines)
+  if events := processLines(&tracker, lines); len(events) != 0 {
+    t.Errorf("got events %+v from the Zone category", events)
+  }
+}
+
+func TestTrackerUpdatingFullEntity(t *testing.T) {
+  for _, format := range testLogFormats {
+    var tracker Tracker
+    lines := formatLines(format.powerPrefix, format.gamePrefix, []string{
+      "CREATE_GAME",
+      "    GameEntity EntityID=1",
+      "    Player EntityID=2 PlayerID=1 GameAccountId=[hi=1 lo=2]",
+      "    Player EntityID=3 PlayerID=2 GameAccountId=[hi=1 lo=3]",
+      "# PlayerID=1, PlayerName=Alice#1234",
+      "    FULL_ENTITY - Updating [entityName=UNKNOWN ENTITY " +
+          "[cardType=INVALID] id=4 zone=DECK zonePos=0 cardId= player=1] " +
+          "CardID=",
+      "        tag=ZONE value=DECK",
+      "        tag=CONTROLLER value=1",
+      "    FULL_ENTITY - Updating [entityName=Leeroy Jenkins id=5 " +
+          "zone=HAND zonePos=1 cardId=EX1_116 player=1] CardID=EX1_116",
+      "        tag=ZONE value=HAND",
+    })
+    processLines(&tracker, lines)
+    game := tracker.Game()
+
+    // NOTE: Player 2 is the only unnamed player, so a reference that is
+    //       mistaken for a name is given to it.
+    if name := game.Player(2).Name; name != "" {
+      t.Errorf("%s: player 2 was named %q", format.name, name)
+    }
+    for _, player := range game.Players {
+      if zone := player.Entity.Zone(); zone != "" {
+        t.Errorf("%s: player %d got the card's zone %s", format.name,
+            player.PlayerId, zone)
+      }
+    }
+    hidden := game.Entities[4]
+    if hidden == nil || hidden.Zone() != "DECK" || hidden.Controller() != 1 {
+      t.Errorf("%s: hidden card's tags were not recorded: %+v", format.name,
+          hidden)
+    }
+    if hidden != nil && hidden.Name != "" {
+      t.Errorf("%s: hidden card was named %q", format.name, hidden.Name)
+    }
+    shown := game.Entities[5]
+    if shown == nil || shown.Name != "Leeroy Jenkins" ||
+        shown.CardId != "EX1_116" || shown.Zone() != "HAND" {
+      t.Errorf("%s: shown card was not recorded: %+v", format.name, shown)
+    }
+  }
+}
@@ -1,79 +1,271 @@
 package main
 
 import (
+  "context"
   "flag"
   "fmt"
   "github.com/pwnall/hsreporter/reporter"
+  "log/slog"
+  "net"
   "os"
+  "os/signal"
+  "path/filepath"
+  "strconv"
+  "syscall"
+  "time"
 )
 
 var logger reporter.State
 
+// Receives hsreporter's own diagnostics.
+var log *slog.Logger
+
 func main() {
-  flag.StringVar(&logger.Config.ServerToken, "token",
-      "", "Token for authenticating to the HTTP endpoint")
-  flag.StringVar(&logger.Config.ServerUrl, "server",
-      "https://histone.herokuapp.com/hsreporter.json",
-      "HTTP endpoint that receives logging information")
-  flag.StringVar(&logger.Config.ConfigFile, "log-config",
-      reporter.DefaultConfigFile(),
-      "Path to Hearthstone's logging configuration file")
-  flag.StringVar(&logger.Config.GameLogFile, "game-log-file",
-      reporter.DefaultGameLogFile(),
-      "Path to Hearthstone's game logging output file")
-  flag.StringVar(&logger.Config.NetLogFile, "net-log-file",
-      reporter.DefaultNetLogFile(),
-      "Path to Hearthstone's network logging output file")
+  if len(os.Args) > 1 {
+    switch os.Args[1] {
+    case "restore-config":
+      restoreConfigMain(os.Args[2:])
+      return
+    case "upload":
+      uploadMain(os.Args[2:])
+      return
+    case "replay":
+      replayMain(os.Args[2:])
+      return
+    case "doctor":
+      doctorMain(os.Args[2:])
+      return
+    }
+  }
+
+  addHearthstoneFlags(flag.CommandLine, &logger.Config)
+  addUploadFlags(flag.CommandLine, &logger.Config)
+  printRedactions := flag.Bool("print-redactions", false,
+      "Show how redaction changes the existing logs, then exit")
+  archiveGames := flag.Bool("archive-games", false,
+      "Keep a compressed copy of each game's log in the state directory")
+  restoreConfigOnExit := flag.Bool("restore-config-on-exit", false,
+      "Restore Hearthstone's original logging config when stopped")
+  flag.DurationVar(&logger.Config.ShutdownTimeout, "shutdown-timeout",
+      reporter.DefaultShutdownTimeout,
+      "Longest time spent uploading queued log data when stopped")
+  statusPort := flag.Int("status-port", 0,
+      "Serve /status and /metrics on this localhost port (0 to disable)")
+  var logFlags logOptions
+  addLogFlags(flag.CommandLine, &logFlags)
   flag.Parse()
+  if *archiveGames {
+    logger.Config.ArchiveDir = filepath.Join(logger.Config.StateDir, "games")
+  }
+  if *statusPort != 0 {
+    // NOTE: The status includes file paths, so it is only served to the
+    //       local machine.
+    logger.Config.StatusAddr = net.JoinHostPort("127.0.0.1",
+        strconv.Itoa(*statusPort))
+  }
 
-  if err := logger.Init(); err != nil {
-    fmt.Println(err)
-    os.Exit(1)
+  if *printRedactions {
+    if err := reporter.PrintRedactions(logger.Config, os.Stdout); err != nil {
+      fmt.Println(err)
+      os.Exit(1)
+    }
+    return
   }
-  fmt.Printf("Logging config: %s\n", logger.Config.ConfigFile)
-  fmt.Printf("Game log: %s\n", logger.Config.GameLogFile)
-  fmt.Printf("Network log: %s\n", logger.Config.NetLogFile)
-  fmt.Printf("Logging categories: %v\n",
-      logger.Uploader.ServerConfig.Categories)
-  fmt.Printf("Uploading old log data: %v\n",
-      logger.Uploader.ServerConfig.ExistingData)
 
-  if err := logger.ConfigLogging(); err != nil {
+  var err error
+  if log, err = openLog(logFlags); err != nil {
     fmt.Println(err)
     os.Exit(1)
   }
-  // NOTE: The uploader must start before any watcher, so it can drain the
-  //       watchers' output channel. Otherwise, a watcher can deadlock in
-  //       Start() while producing old log data.
-  if err := logger.Uploader.Start(); err != nil {
-    fmt.Println(err)
+  logger.Config.Logger = log
+  if err := logger.Init(); err != nil {
+    log.Error("Startup failed", "error", err)
     os.Exit(1)
   }
-  // NOTE: The network log watcher must start first, so that we uploads region
-  //       information to the server before we start uploading game chunks.
-  if err := logger.NetLogWatcher.Start(); err != nil {
-    fmt.Println(err)
-    os.Exit(1)
+  if logger.UsesLogsDir() {
+    log.Info("Reading Hearthstone's logs",
+        "logConfig", logger.Config.ConfigFile,
+        "logsDir", logger.Config.LogsDir,
+        "netLog", logger.Config.NetLogFile)
+  } else {
+    log.Info("Reading Hearthstone's logs",
+        "logConfig", logger.Config.ConfigFile,
+        "gameLog", logger.Config.GameLogFile,
+        "netLog", logger.Config.NetLogFile)
   }
-  if logger.Uploader.ServerConfig.ExistingData {
-    logger.GameLogWatcher.ReportExistingData()
+  log.Info("Keeping state", "stateDir", logger.Config.StateDir,
+      "gameArchive", logger.Config.ArchiveDir)
+  log.Info("Uploading",
+      "categories", logger.Uploader.ServerConfig.Categories,
+      "existingData", logger.Uploader.ServerConfig.ExistingData,
+      "protocol", logger.Uploader.Protocol(),
+      "encoding", logger.Uploader.Encoding(),
+      "batching", fmt.Sprintf("%+v", logger.Uploader.BatchPolicy()))
+  if logger.Config.StatusAddr != "" {
+    log.Info("Serving status", "url",
+        "http://" + logger.StatusServer.Addr() + "/status")
   }
-  if err := logger.GameLogWatcher.Start(); err != nil {
-    fmt.Println(err)
+
+  logger.Config.Hooks = reporter.Hooks{
+    UploadError: func(err error) {
+      log.Warn("Upload error", "error", err)
+    },
+    WatchError: func(err error) {
+      log.Warn("Watch error", "error", err)
+    },
+    ArchiveError: func(err error) {
+      log.Warn("Game archive error", "error", err)
+    },
+    ConfigError: func(err error) {
+      log.Warn("Logging config update error", "error", err)
+    },
+    StatusError: func(err error) {
+      log.Warn("Status server error", "error", err)
+    },
+    Status: logStatus,
+  }
+
+  ctx, stop := context.WithCancel(context.Background())
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+  go func() {
+    <- signals
+    log.Info("Stopping. Press Ctrl+C again to stop right away.")
+    stop()
+    <- signals
+    log.Warn("Stopped without uploading the queued log data.")
+    if *restoreConfigOnExit {
+      restoreConfig()
+    }
     os.Exit(1)
+  }()
+
+  err = logger.Run(ctx)
+  exitStatus := runStatus(err)
+  if *restoreConfigOnExit && !restoreConfig() {
+    exitStatus = 1
   }
+  os.Exit(exitStatus)
+}
 
-  uploadErrors := logger.Uploader.Errors()
-  gameLogWatchErrors := logger.GameLogWatcher.Errors()
-  netLogWatchErrors := logger.NetLogWatcher.Errors()
-  for {
-    select {
-    case uploadErr := <- uploadErrors:
-      fmt.Printf("Upload error: %v\n", uploadErr)
-    case watchErr := <- gameLogWatchErrors:
-      fmt.Printf("Game log watch error: %v\n", watchErr)
-    case watchErr := <- netLogWatchErrors:
-      fmt.Printf("Net log watch error: %v\n", watchErr)
+// logStatus tells the user about the reporter's status changes.
+func logStatus(status reporter.RunStatus) {
+  switch status.Kind {
+  case reporter.RunStarted:
+    for _, source := range status.Resumed {
+      log.Info("Resumed the log where the last run stopped", "source", source)
+    }
+  case reporter.RunConfigChanged:
+    if status.RestartNeeded {
+      log.Info("Restart Hearthstone to start logging the new categories.",
+          "categories", status.ServerConfig.Categories)
+    } else {
+      log.Info("Server config refreshed, no Hearthstone restart needed.")
     }
   }
 }
+
+// restoreConfig puts back Hearthstone's original logging config.
+//
+// It returns false if the config could not be restored.
+func restoreConfig() bool {
+  if _, err := logger.RestoreConfigFile(); err != nil {
+    log.Error("Logging config restore error", "error", err)
+    return false
+  }
+  log.Info("Restored Hearthstone's original logging config.")
+  return true
+}
+
+// runStatus logs the result of running the reporter, and returns the exit
+// status.
+//
+// The exit status is 0 if all the log data was uploaded, 3 if some log data
+// is left for the next run, and 1 if an error occurred.
+func runStatus(err error) int {
+  if err == nil {
+    log.Info("All log data was uploaded.")
+    return 0
+  }
+  if uploadErr, ok := err.(*reporter.UploadError); ok && uploadErr.Fatal {
+    log.Error("Get a new token from your analytics application.",
+        "error", err)
+    return 1
+  }
+  if undelivered, ok := err.(*reporter.UndeliveredError); ok {
+    log.Warn("The log data will be uploaded the next time hsreporter runs.",
+        "pendingBytes", undelivered.PendingBytes)
+    return 3
+  }
+  log.Error("Stopped with an error", "error", err)
+  return 1
+}
+
+// addHearthstoneFlags defines the flags that point to Hearthstone's files.
+func addHearthstoneFlags(flags *flag.FlagSet, config *reporter.Config) {
+  flags.StringVar(&config.ConfigFile, "log-config",
+      reporter.DefaultConfigFile(),
+      "Path to Hearthstone's logging configuration file")
+  flags.StringVar(&config.GameLogFile, "game-log-file",
+      reporter.DefaultGameLogFile(),
+      "Path to Hearthstone's game logging output file")
+  // NOTE: The Logs directory is opt-in, because reading it turns on
+  //       FilePrinting in log.config, which changes where Hearthstone writes
+  //       its logs.
+  logsDirUsage := "Path to Hearthstone's per-category log files (empty to " +
+      "use the game log file)"
+  if defaultLogsDir := reporter.DefaultLogsDir(); defaultLogsDir != "" {
+    logsDirUsage += ", such as " + defaultLogsDir
+  }
+  flags.StringVar(&config.LogsDir, "logs-dir", "", logsDirUsage)
+  flags.StringVar(&config.NetLogFile, "net-log-file",
+      reporter.DefaultNetLogFile(),
+      "Path to Hearthstone's network logging output file")
+}
+
+// addUploadFlags defines the flags that configure uploading.
+//
+// The flags are shared by all the commands that upload log data.
+func addUploadFlags(flags *flag.FlagSet, config *reporter.Config) {
+  flags.StringVar(&config.ServerToken, "token",
+      "", "Token for authenticating to the HTTP endpoint")
+  flags.StringVar(&config.ServerUrl, "server",
+      "https://histone.herokuapp.com/hsreporter.json",
+      "HTTP endpoint that receives logging information")
+  flags.StringVar(&config.StateDir, "state-dir",
+      reporter.DefaultStateDir(),
+      "Directory for the reporter's state, such as not yet uploaded logs")
+  defaultBatching := reporter.DefaultBatchPolicy()
+  flags.IntVar(&config.Batching.MaxBytes, "batch-max-bytes",
+      defaultBatching.MaxBytes,
+      "Maximum number of log bytes in an HTTP request (0 for no limit)")
+  flags.IntVar(&config.Batching.MaxLines, "batch-max-lines",
+      defaultBatching.MaxLines,
+      "Maximum number of log lines in an HTTP request (0 for no limit)")
+  flags.DurationVar(&config.Batching.MaxLinger, "batch-max-linger",
+      defaultBatching.MaxLinger,
+      "Longest time a log line waits for other lines to join its request")
+  flags.DurationVar(&config.Batching.MinInterval, "batch-min-interval",
+      defaultBatching.MinInterval,
+      "Shortest time between two consecutive HTTP requests")
+  flags.DurationVar(&config.ConfigRefresh, "config-refresh",
+      time.Hour,
+      "Time between checks for server config changes (0 to disable)")
+  flags.StringVar(&config.IncludeLines, "include-lines", "",
+      "Only upload game log lines that match this regular expression")
+  flags.StringVar(&config.ExcludeLines, "exclude-lines", "",
+      "Do not upload game log lines that match this regular expression")
+  flags.BoolVar(&config.AllCategories, "all-categories", false,
+      "Upload game log lines from categories that the server did not ask for")
+  config.Redaction = reporter.RedactionConfig{
+    BattleTags: reporter.RedactionKeep,
+    AccountIds: reporter.RedactionKeep,
+    IPs: reporter.RedactionKeep,
+  }
+  flags.Var(&config.Redaction.BattleTags, "redact-battletags",
+      "How BattleTags are redacted before uploading (keep, hash or mask)")
+  flags.Var(&config.Redaction.AccountIds, "redact-account-ids",
+      "How account IDs are redacted before uploading (keep, hash or mask)")
+  flags.Var(&config.Redaction.IPs, "redact-ips",
+      "How IP addresses are redacted before uploading (keep, hash or mask)")
+}
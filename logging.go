@@ -0,0 +1,55 @@
+package main
+
+import (
+  "flag"
+  "github.com/pwnall/hsreporter/reporter"
+  "io"
+  "log/slog"
+  "os"
+)
+
+// logOptions holds the flags that configure hsreporter's own logging.
+type logOptions struct {
+  // Path to the file that receives a copy of the log. Empty for no file.
+  file string
+  // The size at which the log file is rotated.
+  maxBytes int64
+  // True if debugging records are logged.
+  verbose bool
+}
+
+// addLogFlags defines the flags that configure hsreporter's own logging.
+func addLogFlags(flags *flag.FlagSet, options *logOptions) {
+  flags.StringVar(&options.file, "log-file", "",
+      "File that receives a copy of hsreporter's log (rotated by size)")
+  flags.Int64Var(&options.maxBytes, "log-file-max-bytes",
+      reporter.DefaultLogFileMaxBytes,
+      "Size at which the log file is rotated (0 for no rotation)")
+  flags.BoolVar(&options.verbose, "verbose", false,
+      "Log each upload request and log file read")
+}
+
+// openLog creates the logger for hsreporter's diagnostics.
+//
+// The records are written to the standard output, and to the log file if
+// the options ask for one. The log file is not buffered, so it is left open
+// until the process exits. It returns any error encountered.
+func openLog(options logOptions) (*slog.Logger, error) {
+  var writer io.Writer = os.Stdout
+  if options.file != "" {
+    file, err := reporter.OpenRotatingFile(options.file, options.maxBytes)
+    if err != nil {
+      return nil, err
+    }
+    // NOTE: The file comes first, so it still gets the records when the
+    //       standard output is closed, as it is in some background setups.
+    writer = io.MultiWriter(file, os.Stdout)
+  }
+
+  level := slog.LevelInfo
+  if options.verbose {
+    level = slog.LevelDebug
+  }
+  handler := slog.NewTextHandler(writer, &slog.HandlerOptions{Level: level})
+  return slog.New(handler), nil
+}
@@ -0,0 +1,94 @@
+package parser
+
+import (
+  "strings"
+)
+
+// ParseFields breaks down a payload made up of key=value fields.
+//
+// It returns the text before the first field, and the fields. Values extend
+// until the next key, so they can contain spaces, as in "Entity=Name#1234
+// tag=PLAYSTATE". Values in square brackets, such as "[entityName=Leeroy
+// Jenkins id=21 zone=HAND]", are kept whole, even if they contain fields.
+func ParseFields(payload string) (string, []Field) {
+  type keyPosition struct {
+    // The index of the key's first character.
+    start int
+    // The index of the = after the key.
+    equals int
+  }
+
+  var keys []keyPosition
+  depth := 0
+  for i := 0; i < len(payload); i += 1 {
+    switch payload[i] {
+    case '[':
+      depth += 1
+      continue
+    case ']':
+      if depth > 0 {
+        depth -= 1
+      }
+      continue
+    }
+    if depth > 0 || (i > 0 && payload[i - 1] != ' ') {
+      continue
+    }
+    if equals := scanKey(payload, i); equals != -1 {
+      keys = append(keys, keyPosition{start: i, equals: equals})
+      i = equals
+    }
+  }
+
+  if len(keys) == 0 {
+    return strings.TrimSpace(payload), nil
+  }
+  fields := make([]Field, len(keys))
+  for i, key := range keys {
+    valueEnd := len(payload)
+    if i + 1 < len(keys) {
+      valueEnd = keys[i + 1].start
+    }
+    fields[i] = Field{
+      Key: payload[key.start : key.equals],
+      Value: strings.TrimSpace(payload[key.equals + 1 : valueEnd]),
+    }
+  }
+  return strings.TrimSpace(payload[:keys[0].start]), fields
+}
+
+// ParseBracketed breaks down a value in square brackets into fields.
+//
+// Hearthstone describes entities as "[entityName=Leeroy Jenkins id=21
+// zone=HAND zonePos=3 cardId=EX1_116 player=1]". The boolean is false if the
+// value isn't in square brackets.
+func ParseBracketed(value string) ([]Field, bool) {
+  if len(value) < 2 || value[0] != '[' || value[len(value) - 1] != ']' {
+    return nil, false
+  }
+  _, fields := ParseFields(value[1 : len(value) - 1])
+  return fields, true
+}
+
+// scanKey checks if a key=value field starts at an index in the payload.
+//
+// It returns the index of the = after the key, or -1 if there is no field.
+func scanKey(payload string, start int) int {
+  i := start
+  for i < len(payload) && isKeyChar(payload[i]) {
+    i += 1
+  }
+  if i == start || i == len(payload) || payload[i] != '=' {
+    return -1
+  }
+  if payload[start] >= '0' && payload[start] <= '9' {
+    return -1
+  }
+  return i
+}
+
+// isKeyChar returns true for the characters that appear in field keys.
+func isKeyChar(char byte) bool {
+  return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') ||
+      (char >= '0' && char <= '9') || char == '_'
+}
@@ -0,0 +1,192 @@
+// Package parser turns Hearthstone's game logging output into records.
+//
+// Hearthstone's game log lines look as follows.
+//
+//     [Power] D 20:15:03.12 GameState.DebugPrintPower() -     tag=TURN value=3
+//
+// The category marker comes first. The single-file game log starts every line
+// with it, and the reporter adds it to the lines read from per-category log
+// files, such as Power.log. Per-category log files add a log level and a time
+// stamp, which are missing from the single-file game log. The method that
+// produced the line comes next, followed by the line's payload. The payload is
+// indented to show its nesting, and usually consists of key=value fields.
+package parser
+
+import (
+  "strconv"
+  "strings"
+  "time"
+)
+
+// The number of spaces used by Hearthstone for each level of indentation.
+const indentWidth = 4
+
+// Line is a parsed line of Hearthstone's game logging output.
+//
+// All the fields are optional, because not all lines follow the usual format.
+// Lines that can't be parsed at all only have their Payload set.
+type Line struct {
+  // The logging category, such as "Power". Empty if the line doesn't have a
+  // [Category] marker.
+  Category string
+  // The log level, such as "D" for debugging output. Empty if the line
+  // doesn't have a time stamp.
+  Level string
+  // The time of day when the line was written, as an offset from midnight.
+  // Only valid if HasTime is true.
+  Time time.Duration
+  // True if the line has a time stamp.
+  HasTime bool
+  // The method that wrote the line, such as "GameState.DebugPrintPower".
+  Method string
+  // The payload's indentation level. Nested payloads, such as the tags of a
+  // newly created entity, have higher depths.
+  Depth int
+  // The line's contents after the method name, without the indentation.
+  Payload string
+  // The text at the beginning of the payload, before the first field, such as
+  // "TAG_CHANGE". Empty if the payload starts with a field.
+  Text string
+  // The key=value fields in the payload, in order.
+  Fields []Field
+}
+
+// Field is a key=value pair in a line's payload.
+type Field struct {
+  Key string
+  Value string
+}
+
+// Get returns the value of the first field with the given key.
+//
+// The boolean is false if the line doesn't have the field.
+func (l *Line) Get(key string) (string, bool) {
+  for _, field := range l.Fields {
+    if field.Key == key {
+      return field.Value, true
+    }
+  }
+  return "", false
+}
+
+// Value returns the value of the first field with the given key, or "".
+func (l *Line) Value(key string) string {
+  value, _ := l.Get(key)
+  return value
+}
+
+// Parse breaks down a line of Hearthstone's game logging output.
+//
+// The line's terminator is ignored.
+func Parse(text string) Line {
+  var line Line
+  text = strings.TrimRight(text, "\r\n")
+
+  line.Category, text = parseCategory(text)
+  line.Level, line.Time, line.HasTime, text = parseTimestamp(text)
+  line.Method, text = parseMethod(text)
+  if line.Method != "" {
+    trimmed := strings.TrimLeft(text, " ")
+    line.Depth = (len(text) - len(trimmed)) / indentWidth
+    text = trimmed
+  }
+  line.Payload = text
+  line.Text, line.Fields = ParseFields(text)
+  return line
+}
+
+// ParseBytes is a version of Parse that works on byte slices.
+func ParseBytes(data []byte) Line {
+  return Parse(string(data))
+}
+
+// parseCategory extracts the "[Category] " marker from a line.
+//
+// It returns the category and the remainder of the line.
+func parseCategory(text string) (string, string) {
+  if !strings.HasPrefix(text, "[") {
+    return "", text
+  }
+  end := strings.IndexByte(text, ']')
+  if end <= 1 || strings.IndexByte(text[1:end], ' ') != -1 {
+    return "", text
+  }
+  return text[1:end], strings.TrimPrefix(text[end + 1:], " ")
+}
+
+// parseTimestamp extracts the "D 20:15:03.1234567 " prefix from a line.
+//
+// It returns the level, the time of day, true if the prefix was found, and
+// the remainder of the line.
+func parseTimestamp(text string) (string, time.Duration, bool, string) {
+  // The level is a single letter, followed by a space.
+  if len(text) < 2 || text[1] != ' ' || text[0] < 'A' || text[0] > 'Z' {
+    return "", 0, false, text
+  }
+  end := strings.IndexByte(text[2:], ' ')
+  if end == -1 {
+    return "", 0, false, text
+  }
+  timeOfDay, ok := parseTimeOfDay(text[2 : 2 + end])
+  if !ok {
+    return "", 0, false, text
+  }
+  return text[:1], timeOfDay, true, text[2 + end + 1:]
+}
+
+// parseTimeOfDay parses a "hh:mm:ss.fffffff" time stamp.
+//
+// The fractional seconds are optional, and can have any number of digits.
+func parseTimeOfDay(text string) (time.Duration, bool) {
+  parts := strings.SplitN(text, ":", 3)
+  if len(parts) != 3 {
+    return 0, false
+  }
+  hours, err := strconv.Atoi(parts[0])
+  if err != nil || hours < 0 || hours > 23 {
+    return 0, false
+  }
+  minutes, err := strconv.Atoi(parts[1])
+  if err != nil || minutes < 0 || minutes > 59 {
+    return 0, false
+  }
+  // NOTE: ParseFloat accepts "NaN", "Inf" and signs, which are not valid in
+  //       time stamps.
+  if parts[2] == "" || parts[2][0] < '0' || parts[2][0] > '9' {
+    return 0, false
+  }
+  seconds, err := strconv.ParseFloat(parts[2], 64)
+  if err != nil || seconds >= 61 {
+    return 0, false
+  }
+  return time.Duration(hours) * time.Hour +
+      time.Duration(minutes) * time.Minute +
+      time.Duration(seconds * float64(time.Second) + 0.5), true
+}
+
+// parseMethod extracts the "Class.Method() - " prefix from a line.
+//
+// It returns the method's name and the remainder of the line, including any
+// indentation.
+func parseMethod(text string) (string, string) {
+  end := strings.Index(text, "() -")
+  if end <= 0 {
+    return "", text
+  }
+  method := text[:end]
+  for _, char := range method {
+    if !isMethodChar(char) {
+      return "", text
+    }
+  }
+  rest := text[end + len("() -"):]
+  // NOTE: Lines with an empty payload don't have a space after the dash.
+  return method, strings.TrimPrefix(rest, " ")
+}
+
+// isMethodChar returns true for characters that appear in method names.
+func isMethodChar(char rune) bool {
+  return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') ||
+      (char >= '0' && char <= '9') || char == '_' || char == '.' ||
+      char == '<' || char == '>' || char == '`'
+}
@@ -0,0 +1,240 @@
+package parser
+
+import (
+  "reflect"
+  "testing"
+  "time"
+)
+
+func TestParse(t *testing.T) {
+  tests := []struct {
+    name string
+    text string
+    want Line
+  }{
+    {
+      name: "single-file log, tag change",
+      text: "[Power] GameState.DebugPrintPower() - TAG_CHANGE " +
+          "Entity=GameEntity tag=STEP value=MAIN_READY\r\n",
+      want: Line{
+        Category: "Power",
+        Method: "GameState.DebugPrintPower",
+        Payload: "TAG_CHANGE Entity=GameEntity tag=STEP value=MAIN_READY",
+        Text: "TAG_CHANGE",
+        Fields: []Field{
+          {"Entity", "GameEntity"},
+          {"tag", "STEP"},
+          {"value", "MAIN_READY"},
+        },
+      },
+    },
+    {
+      name: "single-file log, nested tag",
+      text: "[Power] GameState.DebugPrintPower() -         tag=ZONE " +
+          "value=DECK",
+      want: Line{
+        Category: "Power",
+        Method: "GameState.DebugPrintPower",
+        Depth: 2,
+        Payload: "tag=ZONE value=DECK",
+        Fields: []Field{{"tag", "ZONE"}, {"value", "DECK"}},
+      },
+    },
+    {
+      name: "single-file log, old full entity",
+      text: "[Power] GameState.DebugPrintPower() -     FULL_ENTITY - " +
+          "Creating ID=4 CardID=",
+      want: Line{
+        Category: "Power",
+        Method: "GameState.DebugPrintPower",
+        Depth: 1,
+        Payload: "FULL_ENTITY - Creating ID=4 CardID=",
+        Text: "FULL_ENTITY - Creating",
+        Fields: []Field{{"ID", "4"}, {"CardID", ""}},
+      },
+    },
+    {
+      name: "single-file log, player name with spaces",
+      text: "[Power] GameState.DebugPrintPower() - TAG_CHANGE " +
+          "Entity=Some Player#1234 tag=PLAYSTATE value=WON",
+      want: Line{
+        Category: "Power",
+        Method: "GameState.DebugPrintPower",
+        Payload: "TAG_CHANGE Entity=Some Player#1234 tag=PLAYSTATE " +
+            "value=WON",
+        Text: "TAG_CHANGE",
+        Fields: []Field{
+          {"Entity", "Some Player#1234"},
+          {"tag", "PLAYSTATE"},
+          {"value", "WON"},
+        },
+      },
+    },
+    {
+      name: "per-category log, block start",
+      text: "D 20:15:03.1234567 GameState.DebugPrintPower() - BLOCK_START " +
+          "BlockType=PLAY Entity=[entityName=Leeroy Jenkins id=21 " +
+          "zone=HAND zonePos=3 cardId=EX1_116 player=1] EffectCardId= " +
+          "Target=0",
+      want: Line{
+        Level: "D",
+        Time: 20 * time.Hour + 15 * time.Minute +
+            3123456700 * time.Nanosecond,
+        HasTime: true,
+        Method: "GameState.DebugPrintPower",
+        Payload: "BLOCK_START BlockType=PLAY Entity=[entityName=Leeroy " +
+            "Jenkins id=21 zone=HAND zonePos=3 cardId=EX1_116 player=1] " +
+            "EffectCardId= Target=0",
+        Text: "BLOCK_START",
+        Fields: []Field{
+          {"BlockType", "PLAY"},
+          {"Entity", "[entityName=Leeroy Jenkins id=21 zone=HAND zonePos=3 " +
+              "cardId=EX1_116 player=1]"},
+          {"EffectCardId", ""},
+          {"Target", "0"},
+        },
+      },
+    },
+    {
+      name: "per-category log with category marker, new full entity",
+      text: "[Power] D 20:15:01.5 GameState.DebugPrintPower() -     " +
+          "FULL_ENTITY - Updating [entityName=UNKNOWN ENTITY " +
+          "[cardType=INVALID] id=4 zone=DECK zonePos=0 cardId= player=1] " +
+          "CardID=",
+      want: Line{
+        Category: "Power",
+        Level: "D",
+        Time: 20 * time.Hour + 15 * time.Minute + 1500 * time.Millisecond,
+        HasTime: true,
+        Method: "GameState.DebugPrintPower",
+        Depth: 1,
+        Payload: "FULL_ENTITY - Updating [entityName=UNKNOWN ENTITY " +
+            "[cardType=INVALID] id=4 zone=DECK zonePos=0 cardId= " +
+            "player=1] CardID=",
+        Text: "FULL_ENTITY - Updating [entityName=UNKNOWN ENTITY " +
+            "[cardType=INVALID] id=4 zone=DECK zonePos=0 cardId= player=1]",
+        Fields: []Field{{"CardID", ""}},
+      },
+    },
+    {
+      name: "per-category log, game info",
+      text: "D 20:15:01.4 GameState.DebugPrintGame() - PlayerID=1, " +
+          "PlayerName=Some Player#1234",
+      want: Line{
+        Level: "D",
+        Time: 20 * time.Hour + 15 * time.Minute + 1400 * time.Millisecond,
+        HasTime: true,
+        Method: "GameState.DebugPrintGame",
+        Payload: "PlayerID=1, PlayerName=Some Player#1234",
+        Fields: []Field{
+          {"PlayerID", "1,"},
+          {"PlayerName", "Some Player#1234"},
+        },
+      },
+    },
+    {
+      name: "empty payload",
+      text: "[Power] GameState.DebugPrintPower() -",
+      want: Line{Category: "Power", Method: "GameState.DebugPrintPower"},
+    },
+    {
+      name: "not a method line",
+      text: "[Zone] ZoneChangeList.ProcessChanges() - id=1 local=False",
+      want: Line{
+        Category: "Zone",
+        Method: "ZoneChangeList.ProcessChanges",
+        Payload: "id=1 local=False",
+        Fields: []Field{{"id", "1"}, {"local", "False"}},
+      },
+    },
+    {
+      name: "unparseable line",
+      text: "Platform assembly: C:\\Hearthstone\\Data\\Managed\\x.dll",
+      want: Line{
+        Payload: "Platform assembly: C:\\Hearthstone\\Data\\Managed\\x.dll",
+        Text: "Platform assembly: C:\\Hearthstone\\Data\\Managed\\x.dll",
+      },
+    },
+    {
+      name: "invalid time stamp",
+      text: "D 25:00:00.0 GameState.DebugPrintPower() - CREATE_GAME",
+      want: Line{
+        Payload: "D 25:00:00.0 GameState.DebugPrintPower() - CREATE_GAME",
+        Text: "D 25:00:00.0 GameState.DebugPrintPower() - CREATE_GAME",
+      },
+    },
+  }
+  for _, test := range tests {
+    got := Parse(test.text)
+    if !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s: Parse(%q)\n got %+v\nwant %+v", test.name, test.text,
+          got, test.want)
+    }
+  }
+}
+
+func TestParseFields(t *testing.T) {
+  tests := []struct {
+    payload string
+    wantText string
+    wantFields []Field
+  }{
+    {"CREATE_GAME", "CREATE_GAME", nil},
+    {"", "", nil},
+    {"GameEntity EntityID=1", "GameEntity", []Field{{"EntityID", "1"}}},
+    {
+      "Player EntityID=2 PlayerID=1 GameAccountId=[hi=144115193835963207 " +
+          "lo=30722021]",
+      "Player",
+      []Field{
+        {"EntityID", "2"},
+        {"PlayerID", "1"},
+        {"GameAccountId", "[hi=144115193835963207 lo=30722021]"},
+      },
+    },
+    {
+      "SHOW_ENTITY - Updating Entity=[entityName=UNKNOWN ENTITY " +
+          "[cardType=INVALID] id=33 zone=DECK zonePos=0 cardId= player=2] " +
+          "CardID=EX1_116",
+      "SHOW_ENTITY - Updating",
+      []Field{
+        {"Entity", "[entityName=UNKNOWN ENTITY [cardType=INVALID] id=33 " +
+            "zone=DECK zonePos=0 cardId= player=2]"},
+        {"CardID", "EX1_116"},
+      },
+    },
+    {
+      // Keys can't start with a digit, and need a space before them.
+      "value=1=2 x 3=4",
+      "",
+      []Field{{"value", "1=2 x 3=4"}},
+    },
+  }
+  for _, test := range tests {
+    text, fields := ParseFields(test.payload)
+    if text != test.wantText || !reflect.DeepEqual(fields, test.wantFields) {
+      t.Errorf("ParseFields(%q)\n got %q %+v\nwant %q %+v", test.payload,
+          text, fields, test.wantText, test.wantFields)
+    }
+  }
+}
+
+func TestParseBracketed(t *testing.T) {
+  fields, ok := ParseBracketed("[entityName=UNKNOWN ENTITY " +
+      "[cardType=INVALID] id=4 zone=DECK zonePos=0 cardId= player=1]")
+  want := []Field{
+    {"entityName", "UNKNOWN ENTITY [cardType=INVALID]"},
+    {"id", "4"},
+    {"zone", "DECK"},
+    {"zonePos", "0"},
+    {"cardId", ""},
+    {"player", "1"},
+  }
+  if !ok || !reflect.DeepEqual(fields, want) {
+    t.Errorf("ParseBracketed got %+v %v, want %+v", fields, ok, want)
+  }
+
+  if _, ok := ParseBracketed("Some Player#1234"); ok {
+    t.Errorf("ParseBracketed accepted a value without brackets")
+  }
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "github.com/pwnall/hsreporter/reporter"
+  "os"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// replaySpeed is the value of the replay command's -speed flag.
+//
+// It implements flag.Value. Speeds look like "4x" or "0.5x". "max" replays
+// without waiting between lines.
+type replaySpeed float64
+
+// String implements flag.Value.
+func (s *replaySpeed) String() string {
+  if *s == 0 {
+    return "max"
+  }
+  return strconv.FormatFloat(float64(*s), 'g', -1, 64) + "x"
+}
+
+// Set implements flag.Value.
+func (s *replaySpeed) Set(value string) error {
+  if value == "max" {
+    *s = 0
+    return nil
+  }
+  speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+  if err != nil || !(speed > 0) {
+    return fmt.Errorf("Invalid replay speed %q (use 4x, 0.5x or max)", value)
+  }
+  *s = replaySpeed(speed)
+  return nil
+}
+
+// replayMain implements the replay command.
+//
+// The command writes a recorded game log to a file, with the pace at which
+// Hearthstone wrote it, so a reporter watching the file can be tested
+// without the game.
+func replayMain(args []string) {
+  speed := replaySpeed(1)
+  flags := flag.NewFlagSet("replay", flag.ExitOnError)
+  targetFile := flags.String("target", "",
+      "File that receives the replayed log, such as a test game log file")
+  flags.Var(&speed, "speed",
+      "How much faster than Hearthstone the log is written (4x, or max)")
+  maxDelay := flags.Duration("max-delay", 10 * time.Second,
+      "Longest wait between two lines (0 for no limit)")
+  restartBetweenGames := flags.Bool("restart-between-games", false,
+      "Truncate the target file before each game, as if Hearthstone restarted")
+  flags.Usage = func() {
+    fmt.Fprintf(flags.Output(), "Usage: %s replay [flags] FILE\n\n" +
+        "The wait between lines comes from the lines' time stamps. The " +
+        "lines of\noutput_log.txt have no time stamps, so they are " +
+        "replayed without waiting.\n\n", os.Args[0])
+    flags.PrintDefaults()
+  }
+  flags.Parse(args)
+  if flags.NArg() != 1 || *targetFile == "" {
+    flags.Usage()
+    os.Exit(2)
+  }
+
+  var replayer reporter.Replayer
+  replayer.Init(*targetFile, float64(speed))
+  replayer.SetMaxDelay(*maxDelay)
+  replayer.SetRestartBetweenGames(*restartBetweenGames)
+  fmt.Printf("Replaying %s into %s at %s speed\n", flags.Arg(0), *targetFile,
+      speed.String())
+  err := replayer.Replay(flags.Arg(0))
+  stats := replayer.Stats()
+  fmt.Printf("Replayed %d lines, %d games, %d truncations\n", stats.Lines,
+      stats.Games, stats.Truncations)
+  if err != nil {
+    fmt.Println(err)
+    os.Exit(1)
+  }
+}
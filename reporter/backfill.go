@@ -0,0 +1,168 @@
+package reporter
+
+import (
+  "bufio"
+  "bytes"
+  "github.com/klauspost/compress/zstd"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// BackfillSpoolDir returns the directory of the spool used by backfills.
+//
+// Backfilled logging output is queued separately from the live logs' output,
+// so a large backfill never delays the live logs' upload.
+func BackfillSpoolDir(stateDir string) string {
+  return filepath.Join(stateDir, "backfill-spool")
+}
+
+// InitBackfill sets up the logger's state for uploading old log files.
+//
+// The lines read by BackfillFile go through the same filters and processing
+// as the live game log's lines, and are uploaded by the same uploader. The
+// caller must have set up the logger's configuration. It returns any error
+// encountered.
+func (s *State) InitBackfill(markBackfill bool) error {
+  err := s.Redactor.Init(s.Config.Redaction, s.Config.StateDir)
+  if err != nil {
+    return err
+  }
+  // The pipeline is only used through Process, so it has no output channel.
+  s.Pipeline.Init(nil)
+  // NOTE: The splitter has no state file, because the backfilled games have
+  //       nothing to do with the game in progress in the live logs.
+  s.Pipeline.Add(&s.GameSplitter)
+  if s.Config.Redaction.Enabled() {
+    s.Pipeline.Add(&s.Redactor)
+  }
+  gameFilter, err := s.gameLogFilter()
+  if err != nil {
+    return err
+  }
+  s.backfillFilter = AllFilters(NewPrefixFilter("["), gameFilter)
+
+  err = s.Spool.Init(BackfillSpoolDir(s.Config.StateDir))
+  if err != nil {
+    return err
+  }
+  s.Spool.SetLogger(s.logger())
+  // NOTE: BackfillFile queues lines directly in the spool, so the uploader's
+  //       line channel is closed right away.
+  logLines := make(chan LogLine)
+  close(logLines)
+  s.Uploader.Init(s.Config.ServerUrl, s.Config.ServerToken, logLines,
+      &s.Spool)
+  s.Uploader.SetLogger(s.logger())
+  s.Uploader.SetBatchPolicy(s.Config.Batching)
+  s.Uploader.SetBackfill(markBackfill)
+  if err := s.Uploader.FetchConfig(); err != nil {
+    return err
+  }
+  return s.updateFilters(s.Uploader.ServerConfig)
+}
+
+// BackfillFile queues the lines of an old game log file for uploading.
+//
+// Files whose names end in .zst, such as the logs in the game archive, are
+// decompressed. Per-category log files, such as Power.log, get their
+// category marker added to each line, as LogDirWatcher does. The lines are
+// uploaded with the "game" source. It returns the number of lines queued,
+// and any error encountered.
+func (s *State) BackfillFile(path string) (int, error) {
+  reader, err := openLogFile(path)
+  if err != nil {
+    return 0, err
+  }
+  defer reader.Close()
+
+  linePrefix := backfillLinePrefix(path)
+  bufferedReader := bufio.NewReader(reader)
+  offset := int64(0)
+  queued := 0
+  // NOTE: Each file is treated as if the reporter missed the lines before
+  //       it, so games don't span files.
+  gap := true
+  for {
+    data, err := bufferedReader.ReadBytes('\n')
+    dataSize := int64(len(data))
+    if len(data) > 0 && linePrefix != nil {
+      data = append(append(make([]byte, 0, len(linePrefix) + len(data)),
+          linePrefix...), data...)
+    }
+    if dataSize > 0 && s.backfillFilter.Accept(
+        bytes.TrimRight(data, "\r\n")) {
+      line := LogLine{Source: "game", Offset: offset, Data: data, gap: gap}
+      gap = false
+      if line, ok := s.Pipeline.Process(line); ok {
+        if err := s.Spool.Append(encodeSpoolEntry(line)); err != nil {
+          return queued, err
+        }
+        queued += 1
+      }
+    }
+    offset += dataSize
+    if err == io.EOF {
+      return queued, nil
+    }
+    if err != nil {
+      return queued, err
+    }
+  }
+}
+
+// backfillLinePrefix returns the category marker for a per-category log file.
+//
+// Per-category log files are named after their category, such as Power.log,
+// or Power.log.zst once compressed. It returns nil for other files, such as
+// output_log.txt and the game archive's logs, whose lines already start with
+// the marker.
+func backfillLinePrefix(path string) []byte {
+  name := strings.TrimSuffix(filepath.Base(path), ".zst")
+  if !strings.HasSuffix(name, ".log") {
+    return nil
+  }
+  category := strings.TrimSuffix(name, ".log")
+  if category == "" {
+    return nil
+  }
+  for _, char := range category {
+    if (char < 'a' || char > 'z') && (char < 'A' || char > 'Z') {
+      return nil
+    }
+  }
+  return []byte("[" + category + "] ")
+}
+
+// openLogFile opens a log file for reading.
+//
+// Files whose names end in .zst are decompressed.
+func openLogFile(path string) (io.ReadCloser, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+  if !strings.HasSuffix(path, ".zst") {
+    return file, nil
+  }
+  decoder, err := zstd.NewReader(file)
+  if err != nil {
+    file.Close()
+    return nil, err
+  }
+  return &compressedLogFile{Decoder: decoder, file: file}, nil
+}
+
+// compressedLogFile reads a zstd-compressed log file.
+type compressedLogFile struct {
+  *zstd.Decoder
+  // The compressed file.
+  file *os.File
+}
+
+// Close releases the decoder's resources and closes the compressed file.
+func (f *compressedLogFile) Close() error {
+  f.Decoder.Close()
+  return f.file.Close()
+}
@@ -0,0 +1,159 @@
+package reporter
+
+import (
+  "errors"
+  "time"
+)
+
+// Returned by waitForBatch when it stops waiting to refresh the server config.
+var errConfigRefreshDue = errors.New("Server config refresh due")
+
+// Returned by waitForBatch when the uploader is flushing and the spool is
+// empty.
+var errSpoolDrained = errors.New("Spool drained")
+
+// BatchPolicy controls how logging output is grouped into POST requests.
+//
+// A batch is sent when it reaches MaxBytes or MaxLines, or when its oldest
+// line has waited for MaxLinger. Consecutive requests are at least
+// MinInterval apart. Zero values disable the corresponding bound.
+type BatchPolicy struct {
+  // The maximum size of the logging output in a request.
+  MaxBytes int
+  // The maximum number of log lines in a request.
+  MaxLines int
+  // The longest time that a line waits for other lines to join its request.
+  MaxLinger time.Duration
+  // The shortest time between the starts of two consecutive requests.
+  MinInterval time.Duration
+}
+
+// ServerBatchPolicy is the batching policy in the server's JSON config.
+//
+// The server uses it to trade upload latency for fewer requests. Zero values
+// leave the reporter's own settings unchanged.
+type ServerBatchPolicy struct {
+  MaxBytes int
+  MaxLines int
+  MaxLingerMs int64
+  MinIntervalMs int64
+}
+
+// DefaultBatchPolicy returns the batching policy used when none is given.
+func DefaultBatchPolicy() BatchPolicy {
+  return BatchPolicy{
+    MaxBytes: 1024 * 1024,
+    MaxLines: 10000,
+    MaxLinger: 1 * time.Second,
+    MinInterval: 0,
+  }
+}
+
+// filledBy returns true if a batch with the given number of lines and size
+// reached the policy's limits.
+func (p BatchPolicy) filledBy(lines int, size int) bool {
+  return (p.MaxLines > 0 && lines >= p.MaxLines) ||
+      (p.MaxBytes > 0 && size >= p.MaxBytes)
+}
+
+// Override returns a copy of the policy, updated with the server's settings.
+func (p BatchPolicy) Override(server ServerBatchPolicy) BatchPolicy {
+  if server.MaxBytes > 0 {
+    p.MaxBytes = server.MaxBytes
+  }
+  if server.MaxLines > 0 {
+    p.MaxLines = server.MaxLines
+  }
+  if server.MaxLingerMs > 0 {
+    p.MaxLinger = time.Duration(server.MaxLingerMs) * time.Millisecond
+  }
+  if server.MinIntervalMs > 0 {
+    p.MinInterval = time.Duration(server.MinIntervalMs) * time.Millisecond
+  }
+  return p
+}
+
+// waitForBatch blocks until the batching policy says a request should start.
+//
+// It returns the spooled logging output that goes into the request. The
+// lastPost argument is the time when the previous request started. While
+// there is no logging output to upload, it returns errConfigRefreshDue when a
+// periodic refresh of the server config is due. When the uploader is
+// flushing, the spooled logging output is returned right away, and
+// errSpoolDrained is returned once the spool is empty.
+func (u *Uploader) waitForBatch(lastPost time.Time) (SpoolBatch, error) {
+  policy := u.batchPolicy
+  var firstSeen time.Time
+  for {
+    // NOTE: Entries appended right before Peek may be counted both in the
+    //       batch and as new entries, which only causes an extra Peek.
+    appended := u.spool.AppendedEntries()
+    batch, err := u.spool.Peek(policy.MaxBytes, policy.MaxLines)
+    if err != nil {
+      return SpoolBatch{}, err
+    }
+    if len(batch.Entries) == 0 {
+      if u.isFlushing() {
+        return SpoolBatch{}, errSpoolDrained
+      }
+      select {
+      case <- u.spool.Ready():
+      case <- u.refreshTimer():
+        return SpoolBatch{}, errConfigRefreshDue
+      case <- u.flushing:
+      }
+      continue
+    }
+    if u.isFlushing() {
+      return batch, nil
+    }
+    // NOTE: Peek only marks a batch as full if more entries are waiting
+    //       after it, so a batch that is exactly at the limits isn't.
+    full := batch.Full || policy.filledBy(len(batch.Entries), batch.Size)
+    if firstSeen.IsZero() {
+      firstSeen = time.Now()
+    }
+
+    sendTime := lastPost.Add(policy.MinInterval)
+    if !full {
+      if lingerEnd := firstSeen.Add(policy.MaxLinger);
+          lingerEnd.After(sendTime) {
+        sendTime = lingerEnd
+      }
+    }
+    delay := time.Until(sendTime)
+    if delay <= 0 {
+      return batch, nil
+    }
+
+    timer := time.NewTimer(delay)
+    waitingLoop: for {
+      select {
+      case <- timer.C:
+        break waitingLoop
+      case <- u.flushing:
+        timer.Stop()
+        break waitingLoop
+      case <- u.spool.Ready():
+        // NOTE: Peeking reads the whole batch from disk, so we only peek
+        //       again when the new lines might fill up the batch.
+        if !full && u.batchMayBeFull(policy, batch, appended) {
+          timer.Stop()
+          break waitingLoop
+        }
+      }
+    }
+  }
+}
+
+// batchMayBeFull returns true if the entries appended to the spool since a
+// batch was peeked might bring the batch to the policy's limits.
+//
+// The appended argument is the spool's AppendedEntries count from before the
+// batch was peeked.
+func (u *Uploader) batchMayBeFull(policy BatchPolicy, batch SpoolBatch,
+    appended int64) bool {
+  newEntries := u.spool.AppendedEntries() - appended
+  return policy.filledBy(len(batch.Entries) + int(newEntries),
+      int(u.spool.PendingBytes()))
+}
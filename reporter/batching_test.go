@@ -0,0 +1,67 @@
+package reporter
+
+import (
+  "testing"
+  "time"
+)
+
+func TestWaitForBatchStopsLingeringAtLimits(t *testing.T) {
+  tests := []struct {
+    name string
+    policy BatchPolicy
+    // The entries appended while waitForBatch waits for the first entry's
+    // batch to fill up.
+    entries []string
+    want int
+  }{
+    {
+      name: "line limit",
+      policy: BatchPolicy{MaxLines: 3, MaxLinger: time.Hour},
+      entries: []string{"bb", "cc"},
+      want: 3,
+    },
+    {
+      name: "line limit exceeded",
+      policy: BatchPolicy{MaxLines: 2, MaxLinger: time.Hour},
+      entries: []string{"bb", "cc", "dd"},
+      want: 2,
+    },
+    {
+      name: "byte limit",
+      policy: BatchPolicy{MaxBytes: 40, MaxLinger: time.Hour},
+      entries: []string{"bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"},
+      want: 1,
+    },
+  }
+  for _, test := range tests {
+    spool := openTestSpool(t, t.TempDir())
+    uploader := &Uploader{}
+    uploader.Init("http://localhost/", "token", nil, spool)
+    uploader.SetBatchPolicy(test.policy)
+    appendTestEntries(t, spool, "aa")
+
+    batches := make(chan SpoolBatch, 1)
+    go func() {
+      batch, _ := uploader.waitForBatch(time.Time{})
+      batches <- batch
+    }()
+    for _, entry := range test.entries {
+      time.Sleep(10 * time.Millisecond)
+      appendTestEntries(t, spool, entry)
+    }
+    select {
+    case batch := <- batches:
+      if len(batch.Entries) != test.want {
+        t.Errorf("%s: got %d entries, want %d", test.name,
+            len(batch.Entries), test.want)
+      }
+    case <- time.After(5 * time.Second):
+      t.Errorf("%s: kept waiting after the batch reached its limit",
+          test.name)
+      uploader.cancelRequests()
+      close(uploader.flushing)
+      <- batches
+    }
+    spool.Close()
+  }
+}
@@ -0,0 +1,119 @@
+package reporter
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "io"
+  "io/ioutil"
+  "os"
+)
+
+// The maximum number of bytes at the beginning of a log file that are hashed
+// to recognize the file.
+const checkpointHeadSize = 4096
+
+// LogCheckpoint records how much of a log file was reported.
+//
+// The checkpoint includes a fingerprint of the log file, so a reporter can
+// tell if the file that it finds after a restart is the same file that it was
+// reading before, or if it was truncated or replaced in the meantime.
+type LogCheckpoint struct {
+  // The number of bytes that were reported, counting from the file's start.
+  Offset int64
+  // The file's size when the checkpoint was taken.
+  Size int64
+  // The number of bytes covered by HeadHash.
+  HeadSize int64
+  // Hex-encoded SHA-256 of the file's first HeadSize bytes.
+  HeadHash string
+  // Platform-specific file identifier, such as the inode number.
+  FileId string
+}
+
+// TakeLogCheckpoint computes the checkpoint for a log file.
+//
+// It returns any error encountered.
+// The offset is the number of bytes of the file that were reported.
+func TakeLogCheckpoint(file *os.File, offset int64) (LogCheckpoint, error) {
+  fileInfo, err := file.Stat()
+  if err != nil {
+    return LogCheckpoint{}, err
+  }
+  checkpoint := LogCheckpoint{
+    Offset: offset,
+    Size: fileInfo.Size(),
+    HeadSize: offset,
+    FileId: fileId(file, fileInfo),
+  }
+  if checkpoint.HeadSize > checkpointHeadSize {
+    checkpoint.HeadSize = checkpointHeadSize
+  }
+  checkpoint.HeadHash, err = hashFileHead(file, checkpoint.HeadSize)
+  if err != nil {
+    return LogCheckpoint{}, err
+  }
+  return checkpoint, nil
+}
+
+// Matches checks if a log file is the file that a checkpoint was taken for.
+//
+// It returns any error encountered.
+// A file does not match if it was replaced, or if it was truncated, even if
+// it grew back past the checkpoint's offset afterwards.
+func (c *LogCheckpoint) Matches(file *os.File) (bool, error) {
+  fileInfo, err := file.Stat()
+  if err != nil {
+    return false, err
+  }
+  if fileInfo.Size() < c.Size || fileInfo.Size() < c.Offset {
+    return false, nil
+  }
+  if c.FileId != "" && c.FileId != fileId(file, fileInfo) {
+    return false, nil
+  }
+  headHash, err := hashFileHead(file, c.HeadSize)
+  if err != nil {
+    return false, err
+  }
+  return headHash == c.HeadHash, nil
+}
+
+// ReadLogCheckpoint loads a checkpoint from a state file.
+//
+// It returns nil if the state file does not exist.
+func ReadLogCheckpoint(stateFile string) (*LogCheckpoint, error) {
+  data, err := ioutil.ReadFile(stateFile)
+  if os.IsNotExist(err) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  checkpoint := &LogCheckpoint{}
+  if err := json.Unmarshal(data, checkpoint); err != nil {
+    return nil, err
+  }
+  return checkpoint, nil
+}
+
+// Write saves a checkpoint to a state file.
+//
+// It returns any error encountered.
+func (c *LogCheckpoint) Write(stateFile string) error {
+  data, err := json.Marshal(c)
+  if err != nil {
+    return err
+  }
+  return writeFileAtomically(stateFile, data, 0644)
+}
+
+// hashFileHead returns the hex-encoded SHA-256 of a file's first bytes.
+func hashFileHead(file *os.File, size int64) (string, error) {
+  hash := sha256.New()
+  _, err := io.Copy(hash, io.NewSectionReader(file, 0, size))
+  if err != nil {
+    return "", err
+  }
+  return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,142 @@
+package reporter
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestLogCheckpointMatches(t *testing.T) {
+  const initial = "[Power] line 1\n[Power] line 2\n"
+  tests := []struct {
+    name string
+    // Changes the log file after the checkpoint was taken.
+    change func(path string) error
+    want bool
+  }{
+    {
+      name: "unchanged",
+      change: func(path string) error { return nil },
+      want: true,
+    },
+    {
+      name: "appended",
+      change: func(path string) error {
+        return appendToFile(path, "[Power] line 3\n")
+      },
+      want: true,
+    },
+    {
+      name: "truncated",
+      change: func(path string) error {
+        return os.Truncate(path, 0)
+      },
+      want: false,
+    },
+    {
+      name: "truncated and grown past the offset",
+      change: func(path string) error {
+        if err := os.Truncate(path, 0); err != nil {
+          return err
+        }
+        return appendToFile(path, strings.Repeat("[Zone] new data\n", 10))
+      },
+      want: false,
+    },
+    {
+      name: "rewritten in place with different data",
+      change: func(path string) error {
+        file, err := os.OpenFile(path, os.O_WRONLY, 0)
+        if err != nil {
+          return err
+        }
+        defer file.Close()
+        _, err = file.WriteAt([]byte("[Zone]"), 0)
+        return err
+      },
+      want: false,
+    },
+    {
+      name: "replaced by a copy",
+      change: func(path string) error {
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+          return err
+        }
+        replacement := path + ".new"
+        if err := ioutil.WriteFile(replacement, data, 0644); err != nil {
+          return err
+        }
+        return os.Rename(replacement, path)
+      },
+      want: false,
+    },
+  }
+  for _, test := range tests {
+    path := filepath.Join(t.TempDir(), "output_log.txt")
+    if err := ioutil.WriteFile(path, []byte(initial), 0644); err != nil {
+      t.Fatal(err)
+    }
+    file, err := os.Open(path)
+    if err != nil {
+      t.Fatal(err)
+    }
+    checkpoint, err := TakeLogCheckpoint(file, int64(len(initial)))
+    file.Close()
+    if err != nil {
+      t.Fatalf("%s: TakeLogCheckpoint: %v", test.name, err)
+    }
+
+    if err := test.change(path); err != nil {
+      t.Fatalf("%s: %v", test.name, err)
+    }
+    file, err = os.Open(path)
+    if err != nil {
+      t.Fatal(err)
+    }
+    matches, err := checkpoint.Matches(file)
+    file.Close()
+    if err != nil || matches != test.want {
+      t.Errorf("%s: Matches got %v %v, want %v", test.name, matches, err,
+          test.want)
+    }
+  }
+}
+
+func TestLogCheckpointWriteAndRead(t *testing.T) {
+  stateFile := filepath.Join(t.TempDir(), "game-log.checkpoint")
+  checkpoint, err := ReadLogCheckpoint(stateFile)
+  if err != nil || checkpoint != nil {
+    t.Fatalf("ReadLogCheckpoint of a missing file got %+v %v", checkpoint,
+        err)
+  }
+
+  saved := LogCheckpoint{
+    Offset: 30,
+    Size: 45,
+    HeadSize: 30,
+    HeadHash: "abcdef",
+    FileId: "2049:1234",
+  }
+  if err := saved.Write(stateFile); err != nil {
+    t.Fatalf("Write: %v", err)
+  }
+  checkpoint, err = ReadLogCheckpoint(stateFile)
+  if err != nil || checkpoint == nil || *checkpoint != saved {
+    t.Errorf("ReadLogCheckpoint got %+v %v, want %+v", checkpoint, err,
+        saved)
+  }
+}
+
+// appendToFile adds data to the end of a file.
+func appendToFile(path string, data string) error {
+  file, err := os.OpenFile(path, os.O_WRONLY | os.O_APPEND, 0)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+  _, err = file.WriteString(data)
+  return err
+}
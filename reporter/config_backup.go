@@ -0,0 +1,101 @@
+package reporter
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+)
+
+// ConfigBackup holds Hearthstone's logging config before the reporter changed
+// it.
+type ConfigBackup struct {
+  // False if the logging config file did not exist.
+  Existed bool
+  // The logging config file's contents.
+  Contents string
+}
+
+// ConfigBackupFile returns the path to the logging config's backup.
+func ConfigBackupFile(stateDir string) string {
+  return filepath.Join(stateDir, "log.config.backup")
+}
+
+// BackupConfigFile saves Hearthstone's logging config, unless it was saved.
+//
+// It returns true if a new backup was taken. Existing backups are never
+// overwritten, so the backup always holds the config from before the first
+// change made by the reporter.
+func BackupConfigFile(configFile string, backupFile string) (bool, error) {
+  if _, err := os.Stat(backupFile); err == nil {
+    return false, nil
+  } else if !os.IsNotExist(err) {
+    return false, err
+  }
+
+  backup := ConfigBackup{Existed: true}
+  data, err := ioutil.ReadFile(configFile)
+  if os.IsNotExist(err) {
+    backup.Existed = false
+  } else if err != nil {
+    return false, err
+  }
+  backup.Contents = string(data)
+
+  backupJson, err := json.Marshal(&backup)
+  if err != nil {
+    return false, err
+  }
+  if err := os.MkdirAll(filepath.Dir(backupFile), 0755); err != nil {
+    return false, err
+  }
+  if err := writeFileAtomically(backupFile, backupJson, 0644); err != nil {
+    return false, err
+  }
+  return true, nil
+}
+
+// ReadConfigBackup loads a backup taken by BackupConfigFile.
+//
+// It returns nil if there is no backup.
+func ReadConfigBackup(backupFile string) (*ConfigBackup, error) {
+  data, err := ioutil.ReadFile(backupFile)
+  if os.IsNotExist(err) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  backup := &ConfigBackup{}
+  if err := json.Unmarshal(data, backup); err != nil {
+    return nil, err
+  }
+  return backup, nil
+}
+
+// RestoreConfigFile puts back the logging config saved in a backup.
+//
+// It returns false if there is no backup. The backup is removed after it is
+// restored, so the next change made by the reporter takes a new backup.
+func RestoreConfigFile(configFile string, backupFile string) (bool, error) {
+  backup, err := ReadConfigBackup(backupFile)
+  if err != nil || backup == nil {
+    return false, err
+  }
+
+  if backup.Existed {
+    err = writeFileAtomically(configFile, []byte(backup.Contents), 0644)
+  } else {
+    err = os.Remove(configFile)
+    if os.IsNotExist(err) {
+      err = nil
+    }
+  }
+  if err != nil {
+    return false, err
+  }
+  if err := os.Remove(backupFile); err != nil {
+    return false, err
+  }
+  return true, nil
+}
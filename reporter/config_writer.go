@@ -1,34 +1,117 @@
 package reporter
 
 import (
-  "fmt"
+  "io/ioutil"
   "os"
   "path"
+  "path/filepath"
+  "strings"
 )
 
-// WriteConfigFile overwrites Hearthstone's logging config file.
+// logSetting is a key that the reporter needs in a logging category section.
+type logSetting struct {
+  // The key's name.
+  key string
+  // The value that the reporter needs.
+  value string
+  // If false, the value is only used when the key is missing, so the setting
+  // can be changed by the user or by other tools.
+  force bool
+}
+
+// The settings that the reporter needs in each logging category's section.
+var categoryLogSettings = []logSetting{
+  {key: "LogLevel", value: "1", force: true},
+  {key: "FilePrinting", value: "false", force: false},
+  {key: "ConsolePrinting", value: "true", force: true},
+  {key: "Verbose", value: "false", force: false},
+}
+
+// WriteConfigFile updates Hearthstone's logging config file.
 //
 // It returns any error encountered.
-// The file receives the configuration necessary for the uploader.
-func WriteConfigFile(configFile string, logCategories []string) error {
+// The file receives the configuration necessary for the uploader. Sections,
+// keys and comments that the uploader doesn't need are preserved, and the file
+// is replaced atomically, so Hearthstone never sees a partially written file.
+// If filePrinting is true, Hearthstone is also asked to write each category's
+// output to a separate file in its Logs directory.
+func WriteConfigFile(configFile string, logCategories []string,
+    filePrinting bool) error {
   err := os.MkdirAll(path.Dir(configFile), 0755)
   if err != nil {
     return err
   }
-  file, err := os.OpenFile(configFile, os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
-      0644)
-  if err != nil {
+  data, err := ioutil.ReadFile(configFile)
+  if err != nil && !os.IsNotExist(err) {
     return err
   }
-  defer file.Close()
+
+  settings := logSettings(filePrinting)
+  config := ParseLogConfig(data)
+  changed := false
+  for _, category := range logCategories {
+    for _, setting := range settings {
+      if _, exists := config.Get(category, setting.key);
+          exists && !setting.force {
+        continue
+      }
+      if config.Set(category, setting.key, setting.value) {
+        changed = true
+      }
+    }
+  }
+  if !changed && data != nil {
+    return nil
+  }
+  return writeFileAtomically(configFile, config.Bytes(), 0644)
+}
+
+// ConfigProblems lists the settings that the reporter needs, and that are
+// missing from a logging config.
+//
+// The problems are described in the same terms as WriteConfigFile's
+// arguments, such as "[Power] ConsolePrinting is false instead of true".
+func ConfigProblems(config *LogConfig, logCategories []string,
+    filePrinting bool) []string {
+  var problems []string
   for _, category := range logCategories {
-    _, err := fmt.Fprintf(file,
-        "[%s]\nLogLevel=1\nConsolePrinting=true\n", category)
-    if err != nil {
-      return err
+    if config.section(category) == nil {
+      problems = append(problems, "[" + category + "] section is missing")
+      continue
+    }
+    for _, setting := range logSettings(filePrinting) {
+      if !setting.force {
+        continue
+      }
+      value, exists := config.Get(category, setting.key)
+      if !exists {
+        problems = append(problems, "[" + category + "] " + setting.key +
+            " is missing")
+      } else if !strings.EqualFold(value, setting.value) {
+        problems = append(problems, "[" + category + "] " + setting.key +
+            " is " + value + " instead of " + setting.value)
+      }
+    }
+  }
+  return problems
+}
+
+// logSettings returns the settings that the reporter needs in each logging
+// category's section.
+//
+// If filePrinting is true, Hearthstone must write each category's output to
+// a separate file in its Logs directory.
+func logSettings(filePrinting bool) []logSetting {
+  settings := append([]logSetting(nil), categoryLogSettings...)
+  if filePrinting {
+    for i := range settings {
+      if settings[i].key == "FilePrinting" {
+        settings[i] = logSetting{key: "FilePrinting", value: "true",
+                                 force: true}
+      }
     }
   }
-  return nil
+  return settings
 }
 
 // TouchLogFile opens Hearthstone's logging file.
@@ -48,3 +131,34 @@ func TouchLogFile(logFile string) error {
   }
   return file.Close()
 }
+
+// writeFileAtomically replaces a file's contents.
+//
+// It returns any error encountered.
+// The data is written to a temporary file which is then renamed, so readers
+// either see the old contents or the new contents.
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+  tempFile, err := ioutil.TempFile(filepath.Dir(path),
+      "." + filepath.Base(path) + ".tmp")
+  if err != nil {
+    return err
+  }
+  tempPath := tempFile.Name()
+  _, err = tempFile.Write(data)
+  if err == nil {
+    err = tempFile.Sync()
+  }
+  if closeErr := tempFile.Close(); err == nil {
+    err = closeErr
+  }
+  if err == nil {
+    err = os.Chmod(tempPath, perm)
+  }
+  if err == nil {
+    err = os.Rename(tempPath, path)
+  }
+  if err != nil {
+    os.Remove(tempPath)
+  }
+  return err
+}
@@ -0,0 +1,190 @@
+package reporter
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+// A logging config that was edited by hand and by other tools.
+var userLogConfig = []string{
+  "; Hearthstone logging, edited by hand",
+  "[Achievements]",
+  "LogLevel=3",
+  "FilePrinting=true",
+  "ConsolePrinting=false",
+  "Verbose=true",
+  "",
+  "[Power]",
+  "LogLevel = 0",
+  "; Keep the file, Deck Tracker reads it",
+  "FilePrinting=true",
+  "Verbose=true",
+  "",
+  "[Zone]",
+  "logLevel=1",
+  "",
+  "# trailing comment",
+}
+
+func TestWriteConfigFile(t *testing.T) {
+  tests := []struct {
+    name string
+    filePrinting bool
+    newline string
+    want []string
+  }{
+    {
+      name: "game log",
+      newline: "\n",
+      want: []string{
+        "; Hearthstone logging, edited by hand",
+        "[Achievements]",
+        "LogLevel=3",
+        "FilePrinting=true",
+        "ConsolePrinting=false",
+        "Verbose=true",
+        "",
+        "[Power]",
+        "LogLevel=1",
+        "; Keep the file, Deck Tracker reads it",
+        "FilePrinting=true",
+        "Verbose=true",
+        "ConsolePrinting=true",
+        "",
+        "[Zone]",
+        "logLevel=1",
+        "FilePrinting=false",
+        "ConsolePrinting=true",
+        "Verbose=false",
+        "",
+        "# trailing comment",
+        "",
+        "[LoadingScreen]",
+        "LogLevel=1",
+        "FilePrinting=false",
+        "ConsolePrinting=true",
+        "Verbose=false",
+      },
+    },
+    {
+      name: "logs directory, CRLF",
+      filePrinting: true,
+      newline: "\r\n",
+      want: []string{
+        "; Hearthstone logging, edited by hand",
+        "[Achievements]",
+        "LogLevel=3",
+        "FilePrinting=true",
+        "ConsolePrinting=false",
+        "Verbose=true",
+        "",
+        "[Power]",
+        "LogLevel=1",
+        "; Keep the file, Deck Tracker reads it",
+        "FilePrinting=true",
+        "Verbose=true",
+        "ConsolePrinting=true",
+        "",
+        "[Zone]",
+        "logLevel=1",
+        "FilePrinting=true",
+        "ConsolePrinting=true",
+        "Verbose=false",
+        "",
+        "# trailing comment",
+        "",
+        "[LoadingScreen]",
+        "LogLevel=1",
+        "FilePrinting=true",
+        "ConsolePrinting=true",
+        "Verbose=false",
+      },
+    },
+  }
+  categories := []string{"Power", "Zone", "LoadingScreen"}
+  for _, test := range tests {
+    configFile := filepath.Join(t.TempDir(), "log.config")
+    data := strings.Join(userLogConfig, test.newline) + test.newline
+    if err := ioutil.WriteFile(configFile, []byte(data), 0644); err != nil {
+      t.Fatal(err)
+    }
+    err := WriteConfigFile(configFile, categories, test.filePrinting)
+    if err != nil {
+      t.Fatalf("%s: WriteConfigFile: %v", test.name, err)
+    }
+    written, err := ioutil.ReadFile(configFile)
+    if err != nil {
+      t.Fatal(err)
+    }
+    want := strings.Join(test.want, test.newline) + test.newline
+    if string(written) != want {
+      t.Errorf("%s: got\n%s\nwant\n%s", test.name, written, want)
+    }
+    // The user's section is kept byte for byte.
+    achievements := strings.Join(userLogConfig[:7], test.newline)
+    if !strings.HasPrefix(string(written), achievements) {
+      t.Errorf("%s: the [Achievements] section changed", test.name)
+    }
+
+    // Writing the same config again leaves the file alone.
+    oldTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+    if err := os.Chtimes(configFile, oldTime, oldTime); err != nil {
+      t.Fatal(err)
+    }
+    err = WriteConfigFile(configFile, categories, test.filePrinting)
+    if err != nil {
+      t.Fatalf("%s: second WriteConfigFile: %v", test.name, err)
+    }
+    rewritten, err := ioutil.ReadFile(configFile)
+    if err != nil {
+      t.Fatal(err)
+    }
+    fileInfo, err := os.Stat(configFile)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if string(rewritten) != string(written) ||
+        !fileInfo.ModTime().Equal(oldTime) {
+      t.Errorf("%s: second WriteConfigFile changed the file", test.name)
+    }
+    problems := ConfigProblems(ParseLogConfig(rewritten), categories,
+        test.filePrinting)
+    if len(problems) != 0 {
+      t.Errorf("%s: written config has problems %q", test.name, problems)
+    }
+  }
+}
+
+func TestWriteConfigFileCreatesFile(t *testing.T) {
+  configFile := filepath.Join(t.TempDir(), "Hearthstone", "log.config")
+  if err := WriteConfigFile(configFile, []string{"Power"}, false); err != nil {
+    t.Fatalf("WriteConfigFile: %v", err)
+  }
+  written, err := ioutil.ReadFile(configFile)
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := "[Power]\nLogLevel=1\nFilePrinting=false\nConsolePrinting=true\n" +
+      "Verbose=false\n"
+  if string(written) != want {
+    t.Errorf("got %q, want %q", written, want)
+  }
+}
+
+func TestLogConfigRoundTrip(t *testing.T) {
+  tests := []string{
+    "",
+    strings.Join(userLogConfig, "\n") + "\n",
+    strings.Join(userLogConfig, "\r\n") + "\r\n",
+    "[Power]\n  LogLevel = 1 ; odd spacing\n\n\n[ Zone ]\n=\nnot a key\n",
+  }
+  for _, data := range tests {
+    if got := string(ParseLogConfig([]byte(data)).Bytes()); got != data {
+      t.Errorf("round trip of %q got %q", data, got)
+    }
+  }
+}
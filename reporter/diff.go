@@ -0,0 +1,89 @@
+package reporter
+
+import (
+  "bytes"
+  "strings"
+)
+
+// The number of unchanged lines shown around each change by DiffLines.
+const diffContextLines = 2
+
+// DiffLines describes the line changes between two texts.
+//
+// The result resembles a unified diff. Removed lines start with "-", added
+// lines start with "+", and unchanged lines around the changes start with a
+// space. It returns an empty string if the texts have the same lines.
+func DiffLines(oldText string, newText string) string {
+  oldLines := splitLines(oldText)
+  newLines := splitLines(newText)
+
+  // lengths[i][j] is the length of the longest common subsequence of
+  // oldLines[i:] and newLines[j:].
+  lengths := make([][]int, len(oldLines) + 1)
+  for i := range lengths {
+    lengths[i] = make([]int, len(newLines) + 1)
+  }
+  for i := len(oldLines) - 1; i >= 0; i -= 1 {
+    for j := len(newLines) - 1; j >= 0; j -= 1 {
+      if oldLines[i] == newLines[j] {
+        lengths[i][j] = lengths[i + 1][j + 1] + 1
+      } else if lengths[i + 1][j] >= lengths[i][j + 1] {
+        lengths[i][j] = lengths[i + 1][j]
+      } else {
+        lengths[i][j] = lengths[i][j + 1]
+      }
+    }
+  }
+
+  var diff []string
+  for i, j := 0, 0; i < len(oldLines) || j < len(newLines); {
+    if i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j] {
+      diff = append(diff, " " + oldLines[i])
+      i += 1
+      j += 1
+    } else if i < len(oldLines) &&
+        (j == len(newLines) || lengths[i + 1][j] >= lengths[i][j + 1]) {
+      diff = append(diff, "-" + oldLines[i])
+      i += 1
+    } else {
+      diff = append(diff, "+" + newLines[j])
+      j += 1
+    }
+  }
+  return trimDiffContext(diff)
+}
+
+// trimDiffContext drops the unchanged lines that are far from any change.
+func trimDiffContext(diff []string) string {
+  var output bytes.Buffer
+  lastShown := -1
+  for index, line := range diff {
+    near := false
+    for other := index - diffContextLines;
+        other <= index + diffContextLines; other += 1 {
+      if other >= 0 && other < len(diff) && diff[other][0] != ' ' {
+        near = true
+        break
+      }
+    }
+    if !near {
+      continue
+    }
+    if lastShown != -1 && lastShown != index - 1 {
+      output.WriteString("...\n")
+    }
+    output.WriteString(line)
+    output.WriteString("\n")
+    lastShown = index
+  }
+  return output.String()
+}
+
+// splitLines breaks a text into lines, without their terminators.
+func splitLines(text string) []string {
+  text = strings.TrimSuffix(strings.Replace(text, "\r\n", "\n", -1), "\n")
+  if text == "" {
+    return nil
+  }
+  return strings.Split(text, "\n")
+}
@@ -0,0 +1,295 @@
+package reporter
+
+import (
+  "io/ioutil"
+  "log/slog"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// How often the Logs directory is checked for new session folders and files.
+const logDirPollInterval = 1 * time.Second
+
+// LogDirWatcher watches the per-category log files in Hearthstone's Logs
+// directory.
+//
+// When FilePrinting is enabled in the logging config, Hearthstone writes each
+// category's output to a separate file, such as Power.log. Recent game
+// versions create a new session folder in the Logs directory every time the
+// game starts. The watcher follows the most recent session folder, and tails
+// the file of every category that the server asked for.
+//
+// Each reported line is tagged with its category, in the format used by the
+// game log, so "D 20:15:03.1 GameState.DebugPrintPower() - ..." in Power.log
+// becomes "[Power] D 20:15:03.1 GameState.DebugPrintPower() - ...".
+type LogDirWatcher struct {
+  // Path to Hearthstone's Logs directory.
+  logsDir string
+  // Sink for the lines written to the log files.
+  logLines chan<- LogLine
+  // Sink for errors encountered by the watcher and its file watchers.
+  errors chan error
+  // Tells the polling loop when to stop.
+  commands chan int
+  // True while the polling goroutine is running.
+  polling bool
+  // Directory for the file watchers' checkpoints, or "" to disable them.
+  checkpointDir string
+  // True if files that exist when the watcher starts are reported in full.
+  reportExistingData bool
+  // Decides which lines are reported. nil reports all lines.
+  filter LineFilter
+  // Receives the diagnostics of the watcher and its file watchers.
+  logger *slog.Logger
+
+  // Protects the fields below.
+  mutex sync.Mutex
+  // The categories whose log files are watched.
+  categories []string
+  // The session folder whose log files are watched.
+  sessionDir string
+  // The watchers for the category log files in the session folder.
+  watchers map[string]*LogWatcher
+  // The line counts of the file watchers that were stopped, by category.
+  stoppedStats map[string]WatchStats
+}
+
+// Init sets up the watcher's initial state.
+func (l *LogDirWatcher) Init(logsDir string, logLines chan<- LogLine) {
+  l.logsDir = logsDir
+  l.logLines = logLines
+  l.errors = make(chan error, 5)
+  l.commands = make(chan int)
+  l.watchers = make(map[string]*LogWatcher)
+  l.stoppedStats = make(map[string]WatchStats)
+  l.logger = discardLogger()
+}
+
+// SetLogger makes the watcher and its file watchers log their diagnostics.
+//
+// It must be called before Start.
+func (l *LogDirWatcher) SetLogger(logger *slog.Logger) {
+  l.logger = logger
+}
+
+// Errors returns the channel for errors encountered while watching the logs.
+func (l *LogDirWatcher) Errors() <-chan error {
+  return l.errors
+}
+
+// SetCategories changes the logging categories whose files are watched.
+func (l *LogDirWatcher) SetCategories(categories []string) {
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+  l.categories = append([]string(nil), categories...)
+}
+
+// SetFilter configures the watcher to only report some lines.
+//
+// The filter sees lines with their category prefix, such as "[Power] ...".
+// It must be called before Start.
+func (l *LogDirWatcher) SetFilter(filter LineFilter) {
+  l.filter = filter
+}
+
+// UseCheckpoints configures the watcher to persist its progress.
+//
+// Each category's checkpoint is saved in a file in the given directory. See
+// LogWatcher.UseCheckpoint for details.
+func (l *LogDirWatcher) UseCheckpoints(checkpointDir string) {
+  l.checkpointDir = checkpointDir
+}
+
+// ReportExistingData configures the watcher to dump the initial file contents.
+//
+// By default, only data written after Start is called is reported for the
+// files in the session folder that exists when the watcher starts. Files in
+// session folders created later are always reported in full.
+func (l *LogDirWatcher) ReportExistingData() {
+  l.reportExistingData = true
+}
+
+// Start begins watching the log files, and spawns the polling goroutine.
+func (l *LogDirWatcher) Start() error {
+  if err := l.scan(true); err != nil {
+    return err
+  }
+  l.polling = true
+  go l.pollLoop()
+  return nil
+}
+
+// Stop stops watching the log files.
+func (l *LogDirWatcher) Stop() error {
+  if l.polling {
+    l.commands <- 1
+    l.polling = false
+  }
+
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+  return l.stopWatchers()
+}
+
+// Stats returns a summary of the lines read from each category's log files.
+//
+// The line counts include the files in older session folders. The offsets
+// are those of the files being watched. It can be called while the watcher
+// runs.
+func (l *LogDirWatcher) Stats() []WatchStats {
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+
+  statsByCategory := make(map[string]WatchStats)
+  for category, stats := range l.stoppedStats {
+    statsByCategory[category] = stats
+  }
+  for category, watcher := range l.watchers {
+    stats := watcher.Stats()
+    stopped := l.stoppedStats[category]
+    stats.LinesRead += stopped.LinesRead
+    stats.LinesFiltered += stopped.LinesFiltered
+    statsByCategory[category] = stats
+  }
+  categories := make([]string, 0, len(statsByCategory))
+  for category := range statsByCategory {
+    categories = append(categories, category)
+  }
+  sort.Strings(categories)
+  allStats := make([]WatchStats, len(categories))
+  for i, category := range categories {
+    allStats[i] = statsByCategory[category]
+  }
+  return allStats
+}
+
+// pollLoop periodically looks for new session folders and log files.
+func (l *LogDirWatcher) pollLoop() {
+  pollingTicker := time.NewTicker(logDirPollInterval)
+  defer pollingTicker.Stop()
+
+  for {
+    select {
+    case <- pollingTicker.C:
+      if err := l.scan(false); err != nil {
+        l.errors <- err
+      }
+    case command := <- l.commands:
+      if command == 1 {
+        return
+      }
+    }
+  }
+}
+
+// scan starts watching the log files that appeared since the last scan.
+//
+// The initial argument is true for the scan done when the watcher starts.
+func (l *LogDirWatcher) scan(initial bool) error {
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+
+  sessionDir, err := findSessionDir(l.logsDir)
+  if err != nil {
+    return err
+  }
+  if sessionDir == "" {
+    // Hearthstone hasn't created its Logs directory yet.
+    return nil
+  }
+  if sessionDir != l.sessionDir {
+    if err := l.stopWatchers(); err != nil {
+      return err
+    }
+    l.sessionDir = sessionDir
+    l.logger.Info("Watching logs directory", "dir", sessionDir)
+  }
+
+  for _, category := range l.categories {
+    if _, watching := l.watchers[category]; watching {
+      continue
+    }
+    logFile := filepath.Join(sessionDir, category + ".log")
+    if _, err := os.Stat(logFile); os.IsNotExist(err) {
+      continue
+    } else if err != nil {
+      return err
+    }
+
+    watcher := &LogWatcher{}
+    err := watcher.Init(category, logFile, l.filter, l.logLines)
+    if err != nil {
+      return err
+    }
+    // NOTE: All the file watchers share the directory watcher's errors
+    //       channel, so the caller only needs to drain one channel.
+    watcher.errors = l.errors
+    watcher.SetLogger(l.logger)
+    watcher.linePrefix = []byte("[" + category + "] ")
+    if l.checkpointDir != "" {
+      watcher.UseCheckpoint(filepath.Join(l.checkpointDir,
+          "logs-" + category + ".checkpoint"))
+    }
+    // Files created after the watcher started belong to a game session that
+    // the reporter has seen from its beginning.
+    if !initial || l.reportExistingData {
+      watcher.ReportExistingData()
+    }
+    if err := watcher.Start(); err != nil {
+      return err
+    }
+    l.watchers[category] = watcher
+  }
+  return nil
+}
+
+// stopWatchers stops watching all the log files.
+func (l *LogDirWatcher) stopWatchers() error {
+  var err error
+  for category, watcher := range l.watchers {
+    if stopErr := watcher.Stop(); err == nil {
+      err = stopErr
+    }
+    stats := watcher.Stats()
+    stopped := l.stoppedStats[category]
+    stopped.Source = stats.Source
+    stopped.LinesRead += stats.LinesRead
+    stopped.LinesFiltered += stats.LinesFiltered
+    l.stoppedStats[category] = stopped
+    delete(l.watchers, category)
+  }
+  return err
+}
+
+// findSessionDir returns the folder where Hearthstone writes its log files.
+//
+// Recent game versions create a Hearthstone_YYYY_MM_DD_HH_MM_SS folder in the
+// Logs directory every time the game starts. Older versions write directly to
+// the Logs directory. It returns "" if the Logs directory does not exist.
+func findSessionDir(logsDir string) (string, error) {
+  fileInfos, err := ioutil.ReadDir(logsDir)
+  if os.IsNotExist(err) {
+    return "", nil
+  }
+  if err != nil {
+    return "", err
+  }
+
+  var sessionNames []string
+  for _, fileInfo := range fileInfos {
+    if fileInfo.IsDir() &&
+        strings.HasPrefix(fileInfo.Name(), "Hearthstone_") {
+      sessionNames = append(sessionNames, fileInfo.Name())
+    }
+  }
+  if len(sessionNames) == 0 {
+    return logsDir, nil
+  }
+  // The timestamps in the folder names sort chronologically.
+  sort.Strings(sessionNames)
+  return filepath.Join(logsDir, sessionNames[len(sessionNames) - 1]), nil
+}
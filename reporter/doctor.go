@@ -0,0 +1,398 @@
+package reporter
+
+import (
+  "bytes"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// The amount of data at the end of the game log that is searched for the
+// logging categories.
+const doctorLogTailSize = 4 * 1024 * 1024
+
+// A log file written within this time is considered to be in use.
+const doctorRecentWrite = 10 * time.Minute
+
+// FindingLevel says how serious a Finding is.
+type FindingLevel int
+
+const (
+  // The check passed.
+  FindingOk FindingLevel = iota
+  // The check found something that may stop logs from being uploaded.
+  FindingWarning
+  // The check found something that stops logs from being uploaded.
+  FindingProblem
+)
+
+func (l FindingLevel) String() string {
+  switch l {
+  case FindingOk:
+    return "ok"
+  case FindingWarning:
+    return "warning"
+  default:
+    return "problem"
+  }
+}
+
+// Finding is the result of one of the checks made by Diagnose.
+type Finding struct {
+  Level FindingLevel
+  // What was checked, such as "Game log".
+  Subject string
+  // What the check found.
+  Message string
+  // How to fix the issue. Empty if the check passed.
+  Fix string
+}
+
+// Diagnose checks the setup that the reporter needs to upload logs.
+//
+// It checks the paths to Hearthstone's files, the logging config file's
+// contents, whether Hearthstone is writing its logs, whether other trackers
+// changed the logging config, and whether the server accepts the token. The
+// checks only read files and fetch the server's config, so they can run while
+// a reporter is running. The findings are returned in the order of the
+// checks.
+func Diagnose(config Config) []Finding {
+  d := doctor{config: config, badPaths: make(map[string]bool)}
+  d.checkPaths()
+  serverConfig, serverOk := d.checkServer()
+  if serverOk {
+    d.categories = serverConfig.Categories
+  }
+  d.checkLogConfig()
+  d.checkLogs()
+  return d.findings
+}
+
+// doctor holds the state of a Diagnose call.
+type doctor struct {
+  // The configuration of the diagnosed reporter.
+  config Config
+  // The logging categories requested by the server. nil if the server
+  // could not be reached.
+  categories []string
+  // The paths that failed checkPath, and are not checked any further.
+  badPaths map[string]bool
+  // The results of the checks made so far.
+  findings []Finding
+}
+
+// add records the result of a check.
+func (d *doctor) add(level FindingLevel, subject string, message string,
+    fix string) {
+  d.findings = append(d.findings, Finding{
+    Level: level,
+    Subject: subject,
+    Message: message,
+    Fix: fix,
+  })
+}
+
+// usesLogsDir returns true if the game log is split by category.
+func (d *doctor) usesLogsDir() bool {
+  return d.config.LogsDir != ""
+}
+
+// checkServer fetches the server's config, which also checks the token.
+//
+// It returns false if the config could not be fetched.
+func (d *doctor) checkServer() (ServerConfig, bool) {
+  const subject = "Server"
+  if d.config.ServerToken == "" {
+    d.add(FindingProblem, subject, "No token was given",
+        "Pass -token with the token from your analytics application.")
+    return ServerConfig{}, false
+  }
+
+  var uploader Uploader
+  uploader.Init(d.config.ServerUrl, d.config.ServerToken, nil, nil)
+  if err := uploader.FetchConfig(); err != nil {
+    d.add(FindingProblem, subject,
+        fmt.Sprintf("Could not get the config from %s: %v",
+            d.config.ServerUrl, err),
+        "Check that -server matches the command line given by your " +
+        "analytics application, that this computer can reach the server, " +
+        "and that the token is current. Get a new token from your " +
+        "analytics application if the server says that it is invalid.")
+    return ServerConfig{}, false
+  }
+  d.add(FindingOk, subject,
+      fmt.Sprintf("%s accepted the token, and asked for these categories: %s",
+          d.config.ServerUrl,
+          strings.Join(uploader.ServerConfig.Categories, ", ")), "")
+  return uploader.ServerConfig, true
+}
+
+// checkPaths checks that the paths to Hearthstone's files were found.
+func (d *doctor) checkPaths() {
+  d.checkPath("Logging config", d.config.ConfigFile, "-log-config",
+      "log.config", false)
+  if d.usesLogsDir() {
+    d.checkPath("Logs directory", d.config.LogsDir, "-logs-dir", "Logs",
+        true)
+  } else {
+    d.checkPath("Game log", d.config.GameLogFile, "-game-log-file",
+        "output_log.txt", false)
+  }
+  d.checkPath("Network log", d.config.NetLogFile, "-net-log-file",
+      "ConnectLog.txt", false)
+}
+
+// checkPath checks that one of Hearthstone's files was found.
+//
+// Files that don't exist yet are fine, because the reporter creates them.
+// Their folder must exist, as Hearthstone creates it when it is installed.
+func (d *doctor) checkPath(subject string, path string, flag string,
+    name string, isDir bool) {
+  d.badPaths[path] = true
+  if path == "" {
+    d.add(FindingProblem, subject,
+        "Hearthstone is not installed in a standard location",
+        fmt.Sprintf("Pass %s with the path to Hearthstone's %s.", flag, name))
+    return
+  }
+  parentDir := filepath.Dir(path)
+  if _, err := os.Stat(parentDir); err != nil {
+    d.add(FindingProblem, subject,
+        fmt.Sprintf("%s's folder cannot be read: %v", path, err),
+        fmt.Sprintf("Check that Hearthstone is installed, or pass %s with " +
+            "the path to Hearthstone's %s.", flag, name))
+    return
+  }
+  fileInfo, err := os.Stat(path)
+  if err == nil && fileInfo.IsDir() != isDir {
+    d.add(FindingProblem, subject,
+        fmt.Sprintf("%s is not the expected kind of file", path),
+        fmt.Sprintf("Pass %s with the path to Hearthstone's %s.", flag, name))
+    return
+  }
+  if err != nil && !os.IsNotExist(err) {
+    d.add(FindingProblem, subject, fmt.Sprintf("%s: %v", path, err),
+        "Check the file's permissions.")
+    return
+  }
+  d.badPaths[path] = false
+  d.add(FindingOk, subject, path, "")
+}
+
+// checkLogConfig checks the logging config file's contents.
+func (d *doctor) checkLogConfig() {
+  const subject = "Logging config"
+  if d.badPaths[d.config.ConfigFile] {
+    return
+  }
+  data, err := ioutil.ReadFile(d.config.ConfigFile)
+  if os.IsNotExist(err) {
+    d.add(FindingProblem, subject,
+        fmt.Sprintf("%s does not exist", d.config.ConfigFile),
+        "Run hsreporter, which creates the file, then restart Hearthstone.")
+    return
+  }
+  if err != nil {
+    d.add(FindingProblem, subject, err.Error(),
+        "Check the file's permissions.")
+    return
+  }
+  config := ParseLogConfig(data)
+  if d.categories == nil {
+    return
+  }
+
+  problems := ConfigProblems(config, d.categories, d.usesLogsDir())
+  if len(problems) == 0 {
+    d.add(FindingOk, subject,
+        "Has the settings for all the categories that the server wants", "")
+    return
+  }
+  message := fmt.Sprintf("%s does not have the settings that hsreporter " +
+      "needs: %s", d.config.ConfigFile, strings.Join(problems, "; "))
+  if !d.settingsChanged(config) {
+    d.add(FindingProblem, subject, message,
+        "Run hsreporter, which fixes the file, then restart Hearthstone.")
+    return
+  }
+  d.add(FindingProblem, subject,
+      message + ". Another tracker, such as Track-o-Bot, HearthStats or " +
+      "Hearthstone Tracker, probably changed them",
+      "Do not run other trackers while hsreporter runs. Restart " +
+      "hsreporter, which fixes the file, then restart Hearthstone.")
+}
+
+// settingsChanged returns true if the logging config has different values
+// for the settings that hsreporter writes in its categories' sections.
+//
+// Trackers such as Track-o-Bot, HearthStats and Hearthstone Tracker write
+// their own logging config when they start, and may change the settings that
+// hsreporter needs. Missing settings don't count, because they usually mean
+// that hsreporter didn't run yet. Only the settings that hsreporter forces
+// are compared, because it keeps the user's values of the other settings.
+func (d *doctor) settingsChanged(config *LogConfig) bool {
+  for _, category := range d.categories {
+    for _, setting := range logSettings(d.usesLogsDir()) {
+      if !setting.force {
+        continue
+      }
+      value, exists := config.Get(category, setting.key)
+      if exists && !strings.EqualFold(value, setting.value) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// checkLogs checks that Hearthstone is writing its logs.
+func (d *doctor) checkLogs() {
+  if d.usesLogsDir() {
+    if !d.badPaths[d.config.LogsDir] {
+      d.checkLogsDir()
+    }
+  } else if !d.badPaths[d.config.GameLogFile] {
+    if d.checkLogWrites("Game log", d.config.GameLogFile) {
+      d.checkGameLogCategories()
+    }
+  }
+  if !d.badPaths[d.config.NetLogFile] {
+    d.checkLogWrites("Network log", d.config.NetLogFile)
+  }
+}
+
+// checkLogWrites checks when a log file was last written.
+//
+// It returns true if the file has data.
+func (d *doctor) checkLogWrites(subject string, path string) bool {
+  fileInfo, err := os.Stat(path)
+  if os.IsNotExist(err) {
+    d.add(FindingWarning, subject, fmt.Sprintf("%s does not exist", path),
+        "Run hsreporter, which creates the file, then restart Hearthstone.")
+    return false
+  }
+  if err != nil {
+    d.add(FindingProblem, subject, err.Error(),
+        "Check the file's permissions.")
+    return false
+  }
+  if fileInfo.Size() == 0 {
+    d.add(FindingWarning, subject, fmt.Sprintf("%s is empty", path),
+        "Start Hearthstone, or restart it if it is running.")
+    return false
+  }
+  age := time.Since(fileInfo.ModTime())
+  if age > doctorRecentWrite {
+    d.add(FindingOk, subject,
+        fmt.Sprintf("%s was last written %s ago, so Hearthstone is probably " +
+            "not running", path, age.Round(time.Minute)), "")
+  } else {
+    d.add(FindingOk, subject,
+        fmt.Sprintf("%s was last written %s ago", path,
+            age.Round(time.Second)), "")
+  }
+  return true
+}
+
+// checkGameLogCategories checks that the game log has the output of the
+// logging categories that the server wants.
+//
+// Hearthstone only reads its logging config when it starts, so categories
+// added while the game runs are missing from the game log until the game is
+// restarted.
+func (d *doctor) checkGameLogCategories() {
+  const subject = "Game log categories"
+  if d.categories == nil {
+    return
+  }
+  tail, err := readFileTail(d.config.GameLogFile, doctorLogTailSize)
+  if err != nil {
+    d.add(FindingProblem, subject, err.Error(),
+        "Check the file's permissions.")
+    return
+  }
+  var missing []string
+  for _, category := range d.categories {
+    if !bytes.Contains(tail, []byte("[" + category + "] ")) {
+      missing = append(missing, category)
+    }
+  }
+  if len(missing) > 0 {
+    d.add(FindingWarning, subject,
+        fmt.Sprintf("Hearthstone has not logged these categories since it " +
+            "started: %s", strings.Join(missing, ", ")),
+        "Restart Hearthstone, so it reads the logging config again. If " +
+        "nothing changes, play a game, then run doctor again.")
+    return
+  }
+  d.add(FindingOk, subject, "Hearthstone is logging all the categories", "")
+}
+
+// checkLogsDir checks that Hearthstone writes the files of the logging
+// categories that the server wants.
+func (d *doctor) checkLogsDir() {
+  const subject = "Category logs"
+  if d.categories == nil {
+    return
+  }
+  sessionDir, err := findSessionDir(d.config.LogsDir)
+  if err != nil {
+    d.add(FindingProblem, subject, err.Error(),
+        "Check the folder's permissions.")
+    return
+  }
+  if sessionDir == "" {
+    d.add(FindingWarning, subject,
+        fmt.Sprintf("%s does not exist", d.config.LogsDir),
+        "Start Hearthstone, or restart it if it is running.")
+    return
+  }
+
+  var missing []string
+  var lastWrite time.Time
+  for _, category := range d.categories {
+    fileInfo, err := os.Stat(filepath.Join(sessionDir, category + ".log"))
+    if err != nil {
+      missing = append(missing, category + ".log")
+      continue
+    }
+    if fileInfo.ModTime().After(lastWrite) {
+      lastWrite = fileInfo.ModTime()
+    }
+  }
+  if len(missing) > 0 {
+    d.add(FindingWarning, subject,
+        fmt.Sprintf("Hearthstone did not write %s in %s",
+            strings.Join(missing, ", "), sessionDir),
+        "Restart Hearthstone, so it reads the logging config again. If " +
+        "nothing changes, play a game, then run doctor again.")
+    return
+  }
+  d.add(FindingOk, subject,
+      fmt.Sprintf("Hearthstone wrote all the categories in %s, last %s ago",
+          sessionDir, time.Since(lastWrite).Round(time.Second)), "")
+}
+
+// readFileTail reads up to maxSize bytes from the end of a file.
+func readFileTail(path string, maxSize int64) ([]byte, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+  fileInfo, err := file.Stat()
+  if err != nil {
+    return nil, err
+  }
+  offset := fileInfo.Size() - maxSize
+  if offset < 0 {
+    offset = 0
+  }
+  if _, err := file.Seek(offset, 0); err != nil {
+    return nil, err
+  }
+  return ioutil.ReadAll(file)
+}
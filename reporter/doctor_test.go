@@ -0,0 +1,151 @@
+package reporter
+
+import (
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// newDoctorConfig sets up the paths of a Hearthstone install in a temporary
+// directory.
+func newDoctorConfig(t *testing.T, serverUrl string, logsDir bool) Config {
+  dir := t.TempDir()
+  config := Config{
+    ConfigFile: filepath.Join(dir, "log.config"),
+    NetLogFile: filepath.Join(dir, "ConnectLog.txt"),
+    ServerUrl: serverUrl,
+    ServerToken: "token",
+  }
+  if logsDir {
+    config.LogsDir = filepath.Join(dir, "Logs")
+  } else {
+    config.GameLogFile = filepath.Join(dir, "output_log.txt")
+  }
+  return config
+}
+
+// lastFinding returns the last finding about a subject.
+func lastFinding(findings []Finding, subject string) (Finding, bool) {
+  for i := len(findings) - 1; i >= 0; i -= 1 {
+    if findings[i].Subject == subject {
+      return findings[i], true
+    }
+  }
+  return Finding{}, false
+}
+
+func TestDiagnoseServer(t *testing.T) {
+  tests := []struct {
+    status int
+    body string
+    want FindingLevel
+    wantMessage string
+  }{
+    {http.StatusOK, `{"categories":["Power","Zone"]}`, FindingOk,
+        "Power, Zone"},
+    {http.StatusUnauthorized, `{"error":"Invalid token"}`, FindingProblem,
+        "HTTP 401): Invalid token"},
+    {http.StatusUnauthorized, "", FindingProblem, "HTTP 401"},
+    {http.StatusInternalServerError, "", FindingProblem, "HTTP 500"},
+  }
+  for _, test := range tests {
+    server := httptest.NewServer(http.HandlerFunc(
+        func(writer http.ResponseWriter, request *http.Request) {
+          writer.WriteHeader(test.status)
+          writer.Write([]byte(test.body))
+        }))
+    findings := Diagnose(newDoctorConfig(t, server.URL, false))
+    server.Close()
+
+    finding, ok := lastFinding(findings, "Server")
+    if !ok {
+      t.Errorf("HTTP %d: no server finding in %+v", test.status, findings)
+      continue
+    }
+    if finding.Level != test.want ||
+        !strings.Contains(finding.Message, test.wantMessage) {
+      t.Errorf("HTTP %d: got %v %q, want %v with %q", test.status,
+          finding.Level, finding.Message, test.want, test.wantMessage)
+    }
+  }
+}
+
+func TestDiagnoseLogConfig(t *testing.T) {
+  tests := []struct {
+    name string
+    logsDir bool
+    config string
+    want FindingLevel
+    wantOtherTracker bool
+  }{
+    {
+      name: "written by hsreporter",
+      config: "[Power]\nLogLevel=1\nFilePrinting=false\n" +
+          "ConsolePrinting=true\nVerbose=false\n[Zone]\nLogLevel=1\n" +
+          "ConsolePrinting=true\n",
+      want: FindingOk,
+    },
+    {
+      name: "user's FilePrinting and Verbose",
+      config: "[Power]\nLogLevel=1\nFilePrinting=true\n" +
+          "ConsolePrinting=true\nVerbose=true\n[Zone]\nLogLevel=1\n" +
+          "ConsolePrinting=TRUE\n",
+      want: FindingOk,
+    },
+    {
+      name: "missing settings",
+      config: "[Power]\nLogLevel=1\nConsolePrinting=true\n[Zone]\n",
+      want: FindingProblem,
+    },
+    {
+      name: "changed ConsolePrinting",
+      config: "[Power]\nLogLevel=1\nConsolePrinting=false\n[Zone]\n" +
+          "LogLevel=1\nConsolePrinting=true\n",
+      want: FindingProblem,
+      wantOtherTracker: true,
+    },
+    {
+      name: "logs directory",
+      logsDir: true,
+      config: "[Power]\nLogLevel=1\nFilePrinting=true\n" +
+          "ConsolePrinting=true\n[Zone]\nLogLevel=1\nFilePrinting=True\n" +
+          "ConsolePrinting=true\n",
+      want: FindingOk,
+    },
+    {
+      name: "logs directory, changed FilePrinting",
+      logsDir: true,
+      config: "[Power]\nLogLevel=1\nFilePrinting=false\n" +
+          "ConsolePrinting=true\n[Zone]\nLogLevel=1\nFilePrinting=true\n" +
+          "ConsolePrinting=true\n",
+      want: FindingProblem,
+      wantOtherTracker: true,
+    },
+  }
+  handler := &testServer{config: `{"categories":["Power","Zone"]}`}
+  server := httptest.NewServer(handler)
+  defer server.Close()
+  for _, test := range tests {
+    config := newDoctorConfig(t, server.URL, test.logsDir)
+    err := ioutil.WriteFile(config.ConfigFile, []byte(test.config), 0644)
+    if err != nil {
+      t.Fatal(err)
+    }
+    findings := Diagnose(config)
+
+    finding, _ := lastFinding(findings, "Logging config")
+    otherTracker := strings.Contains(finding.Message, "Another tracker")
+    if finding.Level != test.want || otherTracker != test.wantOtherTracker {
+      t.Errorf("%s: got %v %q", test.name, finding.Level, finding.Message)
+    }
+    for _, finding := range findings {
+      if finding.Level == FindingWarning &&
+          strings.Contains(finding.Message, "tracker") {
+        t.Errorf("%s: got extra warning %q", test.name, finding.Message)
+      }
+    }
+  }
+}
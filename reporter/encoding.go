@@ -0,0 +1,71 @@
+package reporter
+
+import (
+  "bytes"
+  "compress/gzip"
+  "github.com/klauspost/compress/zstd"
+)
+
+// The Content-Encoding values that the uploader can produce.
+var supportedEncodings = []string{"zstd", "gzip"}
+
+// chooseEncoding picks the Content-Encoding used to upload batches.
+//
+// It returns the first encoding accepted by the server that the uploader can
+// produce, or "identity" if the server did not advertise any such encoding.
+func chooseEncoding(serverEncodings []string) string {
+  for _, serverEncoding := range serverEncodings {
+    for _, encoding := range supportedEncodings {
+      if serverEncoding == encoding {
+        return encoding
+      }
+    }
+  }
+  return "identity"
+}
+
+// batchEncoder compresses batch bodies.
+type batchEncoder struct {
+  // The Content-Encoding produced by the encoder.
+  encoding string
+  // Reused across batches, because it has a large internal state.
+  zstdEncoder *zstd.Encoder
+}
+
+// Init sets up the encoder to produce the given Content-Encoding.
+//
+// It returns any error encountered.
+func (e *batchEncoder) Init(encoding string) error {
+  e.encoding = encoding
+  if encoding == "zstd" && e.zstdEncoder == nil {
+    var err error
+    e.zstdEncoder, err = zstd.NewWriter(nil)
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Encode compresses a batch body.
+//
+// It returns any error encountered. The argument is not modified, and is
+// returned as is if the encoder doesn't compress.
+func (e *batchEncoder) Encode(body []byte) ([]byte, error) {
+  switch e.encoding {
+  case "zstd":
+    return e.zstdEncoder.EncodeAll(body, make([]byte, 0, len(body) / 4)), nil
+  case "gzip":
+    var buffer bytes.Buffer
+    writer := gzip.NewWriter(&buffer)
+    if _, err := writer.Write(body); err != nil {
+      return nil, err
+    }
+    if err := writer.Close(); err != nil {
+      return nil, err
+    }
+    return buffer.Bytes(), nil
+  default:
+    return body, nil
+  }
+}
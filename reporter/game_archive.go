@@ -0,0 +1,347 @@
+package reporter
+
+import (
+  "bufio"
+  "encoding/json"
+  "github.com/klauspost/compress/zstd"
+  "github.com/pwnall/hsreporter/game"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// The format of the time stamps in archived games' file names.
+const archiveTimeLayout = "2006-01-02T15-04-05"
+
+// The suffix of the files that hold the logs of games in progress.
+const archivePartialSuffix = ".log.partial"
+
+// GameSummary describes an archived game.
+type GameSummary struct {
+  // The game's ID, which matches the ID in the uploaded log lines.
+  Id string `json:"id"`
+  // "complete" if the whole game was archived, "truncated" if the reporter
+  // missed the game's start, and "interrupted" if the reporter missed the
+  // game's end.
+  Status string `json:"status"`
+  // The time when the reporter read the game's first and last log lines.
+  StartTime time.Time `json:"startTime"`
+  EndTime time.Time `json:"endTime"`
+  // The number of archived log lines.
+  Lines int `json:"lines"`
+  // The number of turns played.
+  Turns int `json:"turns"`
+  // The game's players.
+  Players []PlayerSummary `json:"players"`
+}
+
+// PlayerSummary describes a player in an archived game.
+type PlayerSummary struct {
+  // The player's number in the game.
+  PlayerId int `json:"playerId"`
+  // The player's BattleTag, if known.
+  Name string `json:"name,omitempty"`
+  // The card ID of the player's hero, if known.
+  Hero string `json:"hero,omitempty"`
+  // "WON", "LOST" or "TIED", if the game ended.
+  Result string `json:"result,omitempty"`
+}
+
+// GameArchive keeps a copy of every game's log lines on disk.
+//
+// While a game is in progress, its lines are appended to a file named
+// <start time>_<game ID>.log.partial. When the game ends, the file is
+// compressed to <start time>_<game ID>.log.zst, and a summary of the game is
+// written to <start time>_<game ID>.json.
+//
+// The archive relies on the game IDs assigned by a GameSplitter, so it must
+// come after the splitter in the pipeline. GameArchive implements
+// LineProcessor.
+type GameArchive struct {
+  // The directory that holds the archived games.
+  dir string
+  // Sink for errors encountered while archiving.
+  errors chan error
+  // The ID of the game being archived. Empty outside games.
+  gameId string
+  // The file holding the log lines of the game being archived.
+  file *os.File
+  // The summary of the game being archived.
+  summary GameSummary
+  // Replays the game being archived, to fill in its summary.
+  tracker game.Tracker
+}
+
+// Init sets up the archive's initial state.
+//
+// The currentGameId argument is the ID of the game that is still in
+// progress, according to the GameSplitter. The game's log, if it is in the
+// archive, is reopened, so the game's remaining lines are appended to it. The
+// logs of other games that were in progress when the reporter stopped are
+// archived as interrupted games. It returns any error encountered.
+func (a *GameArchive) Init(dir string, currentGameId string) error {
+  a.dir = dir
+  a.errors = make(chan error, 5)
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return err
+  }
+
+  partialPaths, err := filepath.Glob(filepath.Join(dir,
+      "*" + archivePartialSuffix))
+  if err != nil {
+    return err
+  }
+  var currentPath string
+  for _, partialPath := range partialPaths {
+    _, gameId, _ := parseArchiveName(partialPath)
+    if gameId == currentGameId && currentGameId != "" {
+      currentPath = partialPath
+      continue
+    }
+    if err := a.resume(partialPath, gameId); err != nil {
+      return err
+    }
+    if err := a.finish("interrupted"); err != nil {
+      return err
+    }
+  }
+  if currentPath != "" {
+    return a.resume(currentPath, currentGameId)
+  }
+  return nil
+}
+
+// Errors returns the channel for errors encountered while archiving games.
+func (a *GameArchive) Errors() <-chan error {
+  return a.errors
+}
+
+// Close closes the log of the game in progress, without archiving the game.
+//
+// The next archive that uses the same directory continues the game, if the
+// GameSplitter says that the game is still in progress. It returns any error
+// encountered.
+func (a *GameArchive) Close() error {
+  a.gameId = ""
+  if a.file == nil {
+    return nil
+  }
+  err := a.file.Close()
+  a.file = nil
+  return err
+}
+
+// ProcessLine implements LineProcessor.
+func (a *GameArchive) ProcessLine(line LogLine) (LogLine, bool) {
+  if _, ok := LineCategory(line.Data); !ok {
+    // Lines without a category, such as the network log's lines, don't
+    // belong to games.
+    return line, true
+  }
+
+  if a.gameId != "" && line.GameId != a.gameId {
+    a.reportError(a.finish("interrupted"))
+  }
+  if line.GameId == "" {
+    return line, true
+  }
+  if a.gameId == "" {
+    a.reportError(a.open(line))
+  }
+  a.reportError(a.write(line))
+
+  switch line.GameBoundary {
+  case GameCompleted:
+    a.reportError(a.finish("complete"))
+  case GameTruncated:
+    a.reportError(a.finish("truncated"))
+  }
+  return line, true
+}
+
+// open starts archiving the game that a line belongs to.
+func (a *GameArchive) open(line LogLine) error {
+  a.gameId = line.GameId
+  startTime := line.Time
+  if startTime.IsZero() {
+    startTime = time.Now()
+  }
+  a.tracker = game.Tracker{}
+  a.summary = GameSummary{Id: line.GameId, StartTime: startTime}
+  partialPath := filepath.Join(a.dir,
+      startTime.Format(archiveTimeLayout) + "_" + line.GameId +
+      archivePartialSuffix)
+  file, err := os.OpenFile(partialPath,
+      os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0644)
+  if err != nil {
+    return err
+  }
+  a.file = file
+  return nil
+}
+
+// resume continues archiving a game whose log is already in the archive.
+//
+// The game's archived lines are replayed, to rebuild its summary.
+func (a *GameArchive) resume(partialPath string, gameId string) error {
+  startTime, _, _ := parseArchiveName(partialPath)
+  a.gameId = gameId
+  a.tracker = game.Tracker{}
+  a.summary = GameSummary{Id: gameId, StartTime: startTime}
+
+  file, err := os.OpenFile(partialPath, os.O_RDWR | os.O_APPEND, 0644)
+  if err != nil {
+    return err
+  }
+  a.file = file
+  reader := bufio.NewReader(file)
+  for {
+    data, err := reader.ReadBytes('\n')
+    if len(data) > 0 {
+      a.tracker.ProcessLine(data)
+      a.summary.Lines += 1
+    }
+    if err == io.EOF {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+  }
+}
+
+// write appends a line to the archived log of the game in progress.
+func (a *GameArchive) write(line LogLine) error {
+  if a.file == nil {
+    // Opening the game's log failed, and the error was already reported.
+    return nil
+  }
+  a.tracker.ProcessLine(line.Data)
+  a.summary.Lines += 1
+  a.summary.EndTime = line.Time
+  data := line.Data
+  if len(data) == 0 || data[len(data) - 1] != '\n' {
+    // Lines from sources added by the library's users may lack terminators.
+    data = append(data[:len(data):len(data)], '\n')
+  }
+  _, err := a.file.Write(data)
+  return err
+}
+
+// finish compresses the game's log and writes its summary.
+func (a *GameArchive) finish(status string) error {
+  a.gameId = ""
+  if a.file == nil {
+    return nil
+  }
+  partialPath := a.file.Name()
+  err := a.file.Close()
+  a.file = nil
+  if err != nil {
+    return err
+  }
+
+  basePath := strings.TrimSuffix(partialPath, archivePartialSuffix)
+  if err := compressFile(partialPath, basePath + ".log.zst"); err != nil {
+    return err
+  }
+  a.summary.Status = status
+  a.fillSummary()
+  summaryJson, err := json.MarshalIndent(&a.summary, "", "  ")
+  if err != nil {
+    return err
+  }
+  err = writeFileAtomically(basePath + ".json", summaryJson, 0644)
+  if err != nil {
+    return err
+  }
+  return os.Remove(partialPath)
+}
+
+// fillSummary copies the game's outcome from its tracker into the summary.
+func (a *GameArchive) fillSummary() {
+  trackedGame := a.tracker.Game()
+  if trackedGame == nil {
+    // The archive missed the game's start, so the game can't be replayed.
+    return
+  }
+  a.summary.Turns = trackedGame.Turn()
+  a.summary.Players = nil
+  for _, player := range trackedGame.Players {
+    playerSummary := PlayerSummary{
+      PlayerId: player.PlayerId,
+      Name: player.Name,
+      Result: player.Result(),
+    }
+    if hero := trackedGame.Hero(player); hero != nil {
+      playerSummary.Hero = hero.CardId
+    }
+    a.summary.Players = append(a.summary.Players, playerSummary)
+  }
+}
+
+// reportError sends an archiving error to the errors channel.
+//
+// Errors are dropped if nobody drains the channel, so archiving problems
+// never hold up uploading.
+func (a *GameArchive) reportError(err error) {
+  if err == nil {
+    return
+  }
+  select {
+  case a.errors <- err:
+  default:
+  }
+}
+
+// parseArchiveName extracts the start time and the game ID from the name of
+// an archived game's file.
+func parseArchiveName(path string) (time.Time, string, bool) {
+  name := filepath.Base(path)
+  name = name[:strings.IndexByte(name + ".", '.')]
+  separator := strings.IndexByte(name, '_')
+  if separator == -1 {
+    return time.Time{}, "", false
+  }
+  startTime, err := time.ParseInLocation(archiveTimeLayout, name[:separator],
+      time.Local)
+  if err != nil {
+    return time.Time{}, "", false
+  }
+  return startTime, name[separator + 1:], true
+}
+
+// compressFile writes the zstd-compressed copy of a file.
+//
+// The compressed file is written under a temporary name, and renamed when
+// complete, so a partially written file is never mistaken for a complete one.
+func compressFile(sourcePath string, targetPath string) error {
+  source, err := os.Open(sourcePath)
+  if err != nil {
+    return err
+  }
+  defer source.Close()
+
+  tempPath := targetPath + ".tmp"
+  target, err := os.Create(tempPath)
+  if err != nil {
+    return err
+  }
+  encoder, err := zstd.NewWriter(target)
+  if err == nil {
+    _, err = io.Copy(encoder, source)
+    if closeErr := encoder.Close(); err == nil {
+      err = closeErr
+    }
+  }
+  if closeErr := target.Close(); err == nil {
+    err = closeErr
+  }
+  if err != nil {
+    os.Remove(tempPath)
+    return err
+  }
+  return os.Rename(tempPath, targetPath)
+}
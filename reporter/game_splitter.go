@@ -0,0 +1,215 @@
+package reporter
+
+import (
+  "bytes"
+  "crypto/rand"
+  "encoding/base64"
+  "encoding/json"
+  "github.com/pwnall/hsreporter/parser"
+  "io/ioutil"
+  "os"
+  "strings"
+)
+
+// The method that writes the game's state changes to the Power log.
+const gameStateMethod = "GameState.DebugPrintPower"
+
+// GameSplitter assigns the game log's lines to games.
+//
+// A game starts with the CREATE_GAME line in the Power log, and ends when the
+// game entity's STATE tag changes to COMPLETE. The splitter tags the lines
+// in between with a random game ID, and marks the lines that start and end
+// each game. When the reporter misses the start of a game, for example because
+// it was started in the middle of the game, the game gets an ID when its
+// first state change is seen, and its end is marked as truncated. The same
+// happens when the log that carries the Power lines has a gap. Gaps in logs
+// that only carry other categories, such as Zone.log, don't affect games.
+//
+// The splitter's state is saved whenever a game starts or ends, so a
+// reporter that resumes reading the log where the previous run stopped
+// continues the game in progress.
+//
+// GameSplitter implements LineProcessor.
+type GameSplitter struct {
+  // Path to the file holding the splitter's state.
+  stateFile string
+  // The splitter's state.
+  state gameSplitterState
+  // The sources that reported Power lines.
+  powerSources map[string]bool
+  // The sources that had a gap, and didn't report Power lines since then.
+  gapSources map[string]bool
+}
+
+// gameSplitterState is the part of the splitter's state saved across runs.
+type gameSplitterState struct {
+  // The ID of the game in progress. Empty outside games.
+  GameId string
+  // True if the reporter missed the start of the game in progress.
+  Truncated bool
+  // True if the last game ended, and no game started since.
+  Ended bool
+}
+
+// Init sets up the splitter's initial state.
+//
+// The state left by the previous run is loaded from the state file. It
+// returns any error encountered.
+func (s *GameSplitter) Init(stateFile string) error {
+  s.stateFile = stateFile
+  s.state = gameSplitterState{}
+  s.powerSources = nil
+  s.gapSources = nil
+
+  data, err := ioutil.ReadFile(stateFile)
+  if os.IsNotExist(err) {
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  return json.Unmarshal(data, &s.state)
+}
+
+// GameId returns the ID of the game in progress, or "" outside games.
+func (s *GameSplitter) GameId() string {
+  return s.state.GameId
+}
+
+// ProcessLine implements LineProcessor.
+func (s *GameSplitter) ProcessLine(line LogLine) (LogLine, bool) {
+  category, ok := LineCategory(line.Data)
+  if !ok {
+    // Lines without a category, such as the network log's lines, don't
+    // belong to games.
+    return line, true
+  }
+
+  changed := false
+  if s.sourceMissedPowerLines(line, string(category) == "Power") &&
+      (s.state.GameId != "" || s.state.Ended) {
+    // The reporter missed some lines, so it can't tell if the game in
+    // progress is still going on.
+    s.state = gameSplitterState{}
+    changed = true
+  }
+
+  boundary := NoGameBoundary
+  if string(category) == "Power" {
+    switch classifyPowerLine(line.Data) {
+    case powerGameCreated:
+      s.state = gameSplitterState{GameId: newRandomId()}
+      boundary = GameStarted
+      changed = true
+    case powerGameOver:
+      if s.state.GameId == "" && !s.state.Ended {
+        s.state = gameSplitterState{GameId: newRandomId(), Truncated: true}
+      }
+      if s.state.GameId != "" {
+        line.GameId = s.state.GameId
+        line.GameBoundary = GameCompleted
+        if s.state.Truncated {
+          line.GameBoundary = GameTruncated
+        }
+        s.state = gameSplitterState{Ended: true}
+        s.saveState()
+        return line, true
+      }
+    case powerGameChange:
+      if s.state.GameId == "" && !s.state.Ended {
+        s.state = gameSplitterState{GameId: newRandomId(), Truncated: true}
+        changed = true
+      }
+    }
+  }
+  if changed {
+    s.saveState()
+  }
+
+  line.GameId = s.state.GameId
+  line.GameBoundary = boundary
+  return line, true
+}
+
+// sourceMissedPowerLines returns true if a gap in a line's source may have
+// hidden some Power lines.
+//
+// A source's gap only counts once the source is known to carry Power lines.
+// In the Logs directory, each category has its own source, so gaps in the
+// other categories' files are ignored.
+func (s *GameSplitter) sourceMissedPowerLines(line LogLine,
+    isPower bool) bool {
+  if s.powerSources == nil {
+    s.powerSources = make(map[string]bool)
+    s.gapSources = make(map[string]bool)
+  }
+  if isPower {
+    s.powerSources[line.Source] = true
+  }
+  if line.gap {
+    s.gapSources[line.Source] = true
+  }
+  if !s.gapSources[line.Source] || !s.powerSources[line.Source] {
+    return false
+  }
+  delete(s.gapSources, line.Source)
+  return true
+}
+
+// saveState writes the splitter's state to its state file.
+//
+// Errors are ignored, because they only cause the game in progress to be
+// reported as truncated when the reporter restarts.
+func (s *GameSplitter) saveState() {
+  if s.stateFile == "" {
+    return
+  }
+  data, err := json.Marshal(&s.state)
+  if err != nil {
+    return
+  }
+  writeFileAtomically(s.stateFile, data, 0644)
+}
+
+// The kinds of Power log lines that matter to GameSplitter.
+const (
+  // The line doesn't change the game's state.
+  powerOther = iota
+  // The line creates a game.
+  powerGameCreated
+  // The line ends the game.
+  powerGameOver
+  // The line changes the state of a game in progress.
+  powerGameChange
+)
+
+// classifyPowerLine tells if a Power log line starts or ends a game.
+func classifyPowerLine(data []byte) int {
+  if !bytes.Contains(data, []byte(gameStateMethod + "()")) {
+    return powerOther
+  }
+  line := parser.ParseBytes(data)
+  if line.Method != gameStateMethod {
+    return powerOther
+  }
+  if line.Text == "CREATE_GAME" {
+    return powerGameCreated
+  }
+  if strings.HasPrefix(line.Text, "TAG_CHANGE") &&
+      line.Value("Entity") == "GameEntity" && line.Value("tag") == "STATE" &&
+      line.Value("value") == "COMPLETE" {
+    return powerGameOver
+  }
+  return powerGameChange
+}
+
+// newRandomId returns a random identifier.
+//
+// The identifier only uses the characters in URL-safe base64 encoding.
+func newRandomId() string {
+  idBytes := make([]byte, 16)
+  if _, err := rand.Read(idBytes); err != nil {
+    panic(err)
+  }
+  return strings.TrimRight(base64.URLEncoding.EncodeToString(idBytes), "=")
+}
@@ -0,0 +1,106 @@
+package reporter
+
+import (
+  "testing"
+)
+
+const (
+  testGameCreated = "[Power] GameState.DebugPrintPower() - CREATE_GAME\n"
+  testGameChange = "[Power] GameState.DebugPrintPower() - TAG_CHANGE " +
+      "Entity=GameEntity tag=STEP value=MAIN_READY\n"
+  testGameOver = "[Power] GameState.DebugPrintPower() - TAG_CHANGE " +
+      "Entity=GameEntity tag=STATE value=COMPLETE\n"
+  testZoneChange = "[Zone] ZoneChangeList.ProcessChanges() - id=1 " +
+      "local=False\n"
+)
+
+func TestGameSplitterGaps(t *testing.T) {
+  type step struct {
+    source string
+    gap bool
+    data string
+    // Steps with the same label must get the same game ID, and steps with
+    // different labels must get different IDs. "" means no game.
+    game string
+    boundary GameBoundary
+  }
+  tests := []struct {
+    name string
+    steps []step
+  }{
+    {
+      name: "logs directory, gap in another category",
+      steps: []step{
+        {"Zone", false, testZoneChange, "", NoGameBoundary},
+        {"Power", false, testGameCreated, "a", GameStarted},
+        {"Zone", true, testZoneChange, "a", NoGameBoundary},
+        {"Power", false, testGameChange, "a", NoGameBoundary},
+        {"Zone", true, testZoneChange, "a", NoGameBoundary},
+        {"Power", false, testGameOver, "a", GameCompleted},
+        {"Zone", true, testZoneChange, "", NoGameBoundary},
+        {"Power", false, testGameCreated, "b", GameStarted},
+        {"Zone", false, testZoneChange, "b", NoGameBoundary},
+      },
+    },
+    {
+      name: "logs directory, gap in the Power log",
+      steps: []step{
+        {"Power", false, testGameCreated, "a", GameStarted},
+        {"Zone", false, testZoneChange, "a", NoGameBoundary},
+        {"Power", true, testGameChange, "b", NoGameBoundary},
+        {"Zone", false, testZoneChange, "b", NoGameBoundary},
+        {"Power", false, testGameOver, "b", GameTruncated},
+        {"Power", true, testGameCreated, "c", GameStarted},
+        {"Zone", true, testZoneChange, "c", NoGameBoundary},
+        {"Power", false, testGameOver, "c", GameCompleted},
+      },
+    },
+    {
+      name: "single log, gap in a Zone line",
+      steps: []step{
+        {"game", false, testGameCreated, "a", GameStarted},
+        {"game", true, testZoneChange, "", NoGameBoundary},
+        {"game", false, testGameChange, "b", NoGameBoundary},
+        {"game", false, testGameOver, "b", GameTruncated},
+      },
+    },
+  }
+  for _, test := range tests {
+    splitter := &GameSplitter{}
+    if err := splitter.Init(""); err != nil {
+      t.Fatal(err)
+    }
+    gameIds := map[string]string{}
+    for i, step := range test.steps {
+      line, _ := splitter.ProcessLine(LogLine{Source: step.source,
+          Data: []byte(step.data), gap: step.gap})
+      if line.GameBoundary != step.boundary {
+        t.Errorf("%s: step %d got boundary %v, want %v", test.name, i,
+            line.GameBoundary, step.boundary)
+      }
+      if step.game == "" {
+        if line.GameId != "" {
+          t.Errorf("%s: step %d got game %q, want none", test.name, i,
+              line.GameId)
+        }
+        continue
+      }
+      if gameId, ok := gameIds[step.game]; ok {
+        if line.GameId != gameId {
+          t.Errorf("%s: step %d got game %q, want %q", test.name, i,
+              line.GameId, gameId)
+        }
+        continue
+      }
+      for _, gameId := range gameIds {
+        if line.GameId == gameId {
+          t.Errorf("%s: step %d continued game %q", test.name, i, gameId)
+        }
+      }
+      if line.GameId == "" {
+        t.Errorf("%s: step %d got no game", test.name, i)
+      }
+      gameIds[step.game] = line.GameId
+    }
+  }
+}
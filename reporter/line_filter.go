@@ -0,0 +1,239 @@
+package reporter
+
+import (
+  "bytes"
+  "fmt"
+  "regexp"
+  "sync"
+)
+
+// LineFilter decides which log lines are reported.
+//
+// Filters may be shared by watchers that run on different goroutines, so
+// their methods must be safe for concurrent use.
+type LineFilter interface {
+  // Accept returns true if the line should be reported.
+  //
+  // The line does not include its terminator.
+  Accept(line []byte) bool
+}
+
+// PrefixFilter accepts the lines that start with one of its prefixes.
+type PrefixFilter struct {
+  prefixes [][]byte
+}
+
+// NewPrefixFilter creates a filter that accepts lines with a given prefix.
+func NewPrefixFilter(prefixes ...string) *PrefixFilter {
+  filter := &PrefixFilter{}
+  for _, prefix := range prefixes {
+    filter.prefixes = append(filter.prefixes, []byte(prefix))
+  }
+  return filter
+}
+
+// Accept implements LineFilter.
+func (f *PrefixFilter) Accept(line []byte) bool {
+  for _, prefix := range f.prefixes {
+    if bytes.HasPrefix(line, prefix) {
+      return true
+    }
+  }
+  return false
+}
+
+// CategoryFilter accepts the lines output by some logging categories.
+//
+// Hearthstone starts each line in the game log with its logging category in
+// square brackets, such as "[Power] ". Lines without a category are rejected.
+type CategoryFilter struct {
+  // Protects the fields below.
+  mutex sync.RWMutex
+  // The accepted categories.
+  categories map[string]bool
+}
+
+// NewCategoryFilter creates a filter that accepts some logging categories.
+func NewCategoryFilter(categories []string) *CategoryFilter {
+  filter := &CategoryFilter{}
+  filter.SetCategories(categories)
+  return filter
+}
+
+// SetCategories changes the categories accepted by the filter.
+func (f *CategoryFilter) SetCategories(categories []string) {
+  categorySet := make(map[string]bool)
+  for _, category := range categories {
+    categorySet[category] = true
+  }
+
+  f.mutex.Lock()
+  defer f.mutex.Unlock()
+  f.categories = categorySet
+}
+
+// Accept implements LineFilter.
+func (f *CategoryFilter) Accept(line []byte) bool {
+  category, ok := LineCategory(line)
+  if !ok {
+    return false
+  }
+
+  f.mutex.RLock()
+  defer f.mutex.RUnlock()
+  return f.categories[string(category)]
+}
+
+// LineCategory extracts the logging category from a game log line.
+//
+// The boolean is false if the line does not start with a [Category] marker.
+func LineCategory(line []byte) ([]byte, bool) {
+  if len(line) == 0 || line[0] != byte('[') {
+    return nil, false
+  }
+  end := bytes.IndexByte(line, byte(']'))
+  if end <= 1 || bytes.IndexByte(line[1:end], byte(' ')) != -1 {
+    return nil, false
+  }
+  return line[1:end], true
+}
+
+// RegexpFilter accepts the lines that match regular expressions.
+type RegexpFilter struct {
+  // If not nil, lines must match this expression to be accepted.
+  include *regexp.Regexp
+  // If not nil, lines that match this expression are rejected.
+  exclude *regexp.Regexp
+}
+
+// NewRegexpFilter creates a filter that matches lines against expressions.
+//
+// Empty expressions are ignored, so NewRegexpFilter("", "") accepts all lines.
+// It returns any error encountered while compiling the expressions.
+func NewRegexpFilter(include string, exclude string) (*RegexpFilter, error) {
+  filter := &RegexpFilter{}
+  var err error
+  if include != "" {
+    if filter.include, err = regexp.Compile(include); err != nil {
+      return nil, err
+    }
+  }
+  if exclude != "" {
+    if filter.exclude, err = regexp.Compile(exclude); err != nil {
+      return nil, err
+    }
+  }
+  return filter, nil
+}
+
+// Accept implements LineFilter.
+func (f *RegexpFilter) Accept(line []byte) bool {
+  if f.include != nil && !f.include.Match(line) {
+    return false
+  }
+  if f.exclude != nil && f.exclude.Match(line) {
+    return false
+  }
+  return true
+}
+
+// DynamicFilter delegates to a filter that can be replaced while in use.
+//
+// It is used for filters that come from the server's config, which can change
+// while the reporter runs.
+type DynamicFilter struct {
+  // Protects the fields below.
+  mutex sync.RWMutex
+  // The filter that decides which lines are accepted. nil accepts all lines.
+  filter LineFilter
+}
+
+// Set replaces the filter that decides which lines are accepted.
+//
+// A nil filter accepts all lines.
+func (f *DynamicFilter) Set(filter LineFilter) {
+  f.mutex.Lock()
+  defer f.mutex.Unlock()
+  f.filter = filter
+}
+
+// Accept implements LineFilter.
+func (f *DynamicFilter) Accept(line []byte) bool {
+  f.mutex.RLock()
+  defer f.mutex.RUnlock()
+  return f.filter == nil || f.filter.Accept(line)
+}
+
+// allFilters accepts the lines accepted by all its filters.
+type allFilters []LineFilter
+
+// AllFilters creates a filter that accepts lines accepted by all filters.
+//
+// nil filters are skipped. If no filter is left, it returns nil.
+func AllFilters(filters ...LineFilter) LineFilter {
+  var all allFilters
+  for _, filter := range filters {
+    if filter != nil {
+      all = append(all, filter)
+    }
+  }
+  if len(all) == 0 {
+    return nil
+  }
+  if len(all) == 1 {
+    return all[0]
+  }
+  return all
+}
+
+// Accept implements LineFilter.
+func (f allFilters) Accept(line []byte) bool {
+  for _, filter := range f {
+    if !filter.Accept(line) {
+      return false
+    }
+  }
+  return true
+}
+
+// anyFilter accepts the lines accepted by at least one of its filters.
+type anyFilter []LineFilter
+
+// AnyFilter creates a filter that accepts lines accepted by any filter.
+func AnyFilter(filters ...LineFilter) LineFilter {
+  return anyFilter(filters)
+}
+
+// Accept implements LineFilter.
+func (f anyFilter) Accept(line []byte) bool {
+  for _, filter := range f {
+    if filter.Accept(line) {
+      return true
+    }
+  }
+  return false
+}
+
+// ServerLineFilters is the line filtering requested by the HTTP endpoint.
+type ServerLineFilters struct {
+  // If set, game log lines must match this regular expression to be reported.
+  Include string
+  // If set, game log lines that match this regular expression are not
+  // reported.
+  Exclude string
+}
+
+// Filter returns a filter that implements the server's request.
+//
+// It returns nil if the server didn't ask for filtering, and an error if the
+// server's regular expressions are invalid.
+func (f ServerLineFilters) Filter() (LineFilter, error) {
+  if f.Include == "" && f.Exclude == "" {
+    return nil, nil
+  }
+  filter, err := NewRegexpFilter(f.Include, f.Exclude)
+  if err != nil {
+    return nil, fmt.Errorf("Invalid server line filter: %v", err)
+  }
+  return filter, nil
+}
@@ -0,0 +1,182 @@
+package reporter
+
+import (
+  "bytes"
+  "strings"
+)
+
+// LogConfig is the parsed contents of Hearthstone's logging config file.
+//
+// The file uses the INI format. Each logging category has a section, whose
+// keys control the category's logging output. LogConfig keeps the file's
+// comments, blank lines, and unknown sections and keys, so that changing a
+// setting does not disturb the settings of other tools.
+type LogConfig struct {
+  // The file's sections, in order. The first section holds the lines before
+  // any section header, and has an empty name.
+  sections []*logConfigSection
+  // The line terminator used by the file.
+  newline string
+}
+
+// logConfigSection is a section in Hearthstone's logging config file.
+type logConfigSection struct {
+  // The section's name, without the square brackets.
+  name string
+  // The section's lines, without line terminators. The first line is the
+  // section header, unless this is the nameless first section.
+  lines []string
+}
+
+// ParseLogConfig parses the contents of Hearthstone's logging config file.
+func ParseLogConfig(data []byte) *LogConfig {
+  config := &LogConfig{newline: "\n"}
+  if bytes.Contains(data, []byte("\r\n")) {
+    config.newline = "\r\n"
+  }
+
+  section := &logConfigSection{}
+  config.sections = append(config.sections, section)
+  text := strings.TrimSuffix(string(data), "\n")
+  if text == "" {
+    return config
+  }
+  for _, line := range strings.Split(text, "\n") {
+    line = strings.TrimSuffix(line, "\r")
+    if name, ok := parseSectionHeader(line); ok {
+      section = &logConfigSection{name: name}
+      config.sections = append(config.sections, section)
+    }
+    section.lines = append(section.lines, line)
+  }
+  return config
+}
+
+// Bytes returns the logging config file's contents.
+func (c *LogConfig) Bytes() []byte {
+  var buffer bytes.Buffer
+  for _, section := range c.sections {
+    for _, line := range section.lines {
+      buffer.WriteString(line)
+      buffer.WriteString(c.newline)
+    }
+  }
+  return buffer.Bytes()
+}
+
+// Categories returns the names of the sections in the config.
+func (c *LogConfig) Categories() []string {
+  var names []string
+  for _, section := range c.sections[1:] {
+    names = append(names, section.name)
+  }
+  return names
+}
+
+// Get returns the value of a key in a category's section.
+//
+// The boolean is false if the category or the key does not exist.
+func (c *LogConfig) Get(category string, key string) (string, bool) {
+  section := c.section(category)
+  if section == nil {
+    return "", false
+  }
+  index := section.keyIndex(key)
+  if index == -1 {
+    return "", false
+  }
+  _, value, _ := parseKeyLine(section.lines[index])
+  return value, true
+}
+
+// Set changes the value of a key in a category's section.
+//
+// The section and the key are created if they don't exist. It returns true if
+// the config changed.
+func (c *LogConfig) Set(category string, key string, value string) bool {
+  section := c.section(category)
+  if section == nil {
+    previous := c.sections[len(c.sections) - 1]
+    if count := len(previous.lines);
+        count > 0 && strings.TrimSpace(previous.lines[count - 1]) != "" {
+      previous.lines = append(previous.lines, "")
+    }
+    section = &logConfigSection{
+      name: category,
+      lines: []string{"[" + category + "]"},
+    }
+    c.sections = append(c.sections, section)
+  }
+
+  newLine := key + "=" + value
+  index := section.keyIndex(key)
+  if index == -1 {
+    // Keep the section's trailing blank lines and comments after the new key.
+    insertAt := len(section.lines)
+    for insertAt > 1 && isBlankOrComment(section.lines[insertAt - 1]) {
+      insertAt -= 1
+    }
+    section.lines = append(section.lines, "")
+    copy(section.lines[insertAt + 1:], section.lines[insertAt:])
+    section.lines[insertAt] = newLine
+    return true
+  }
+
+  _, oldValue, _ := parseKeyLine(section.lines[index])
+  if oldValue == value {
+    return false
+  }
+  section.lines[index] = newLine
+  return true
+}
+
+// section returns the section for a category, or nil if it doesn't exist.
+func (c *LogConfig) section(category string) *logConfigSection {
+  for _, section := range c.sections[1:] {
+    if section.name == category {
+      return section
+    }
+  }
+  return nil
+}
+
+// keyIndex returns the index of the line defining a key, or -1 if missing.
+//
+// Key names are matched case-insensitively.
+func (s *logConfigSection) keyIndex(key string) int {
+  for index, line := range s.lines {
+    if lineKey, _, ok := parseKeyLine(line); ok &&
+        strings.EqualFold(lineKey, key) {
+      return index
+    }
+  }
+  return -1
+}
+
+// parseSectionHeader extracts the name from a [Section] line.
+func parseSectionHeader(line string) (string, bool) {
+  line = strings.TrimSpace(line)
+  if len(line) < 2 || line[0] != '[' || line[len(line) - 1] != ']' {
+    return "", false
+  }
+  return strings.TrimSpace(line[1 : len(line) - 1]), true
+}
+
+// parseKeyLine splits a Key=Value line.
+func parseKeyLine(line string) (string, string, bool) {
+  if isBlankOrComment(line) {
+    return "", "", false
+  }
+  separator := strings.IndexByte(line, '=')
+  if separator == -1 {
+    return "", "", false
+  }
+  return strings.TrimSpace(line[:separator]),
+      strings.TrimSpace(line[separator + 1:]), true
+}
+
+// isBlankOrComment returns true for lines that don't hold settings.
+func isBlankOrComment(line string) bool {
+  line = strings.TrimSpace(line)
+  return line == "" || line[0] == ';' || line[0] == '#'
+}
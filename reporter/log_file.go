@@ -0,0 +1,149 @@
+package reporter
+
+import (
+  "fmt"
+  "io/ioutil"
+  "log/slog"
+  "os"
+  "sync"
+)
+
+// The log file size used by hsreporter's command line.
+const DefaultLogFileMaxBytes = 10 * 1024 * 1024
+
+// The number of old log files kept by RotatingFile.
+const logFileBackups = 3
+
+// RotatingFile is a log file that is rotated when it grows too large.
+//
+// When a write would take the file past its size limit, the file is renamed
+// to path.1, path.1 is renamed to path.2, and so on, and a new file is
+// started. The oldest files are deleted, so the logs never use more than a
+// few times the size limit. RotatingFile is safe for concurrent use.
+type RotatingFile struct {
+  // Path to the current log file.
+  path string
+  // The size that triggers a rotation. 0 means that the file is never
+  // rotated.
+  maxBytes int64
+
+  // Protects the fields below.
+  mutex sync.Mutex
+  // The current log file.
+  file *os.File
+  // The current log file's size.
+  size int64
+}
+
+// OpenRotatingFile opens a log file for appending.
+//
+// It returns any error encountered.
+func OpenRotatingFile(path string, maxBytes int64) (*RotatingFile, error) {
+  r := &RotatingFile{path: path, maxBytes: maxBytes}
+  if err := r.open(); err != nil {
+    return nil, err
+  }
+  return r, nil
+}
+
+// Write implements io.Writer.
+//
+// The data is never split across two files, so each record written by a
+// logger stays whole.
+func (r *RotatingFile) Write(data []byte) (int, error) {
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+  if r.file == nil {
+    return 0, os.ErrClosed
+  }
+  if r.maxBytes > 0 && r.size > 0 &&
+      r.size + int64(len(data)) > r.maxBytes {
+    // NOTE: When the renames fail, the record goes to the oversized file,
+    //       and the rotation is tried again on the next write.
+    if err := r.rotate(); err != nil && r.file == nil {
+      return 0, err
+    }
+  }
+  n, err := r.file.Write(data)
+  r.size += int64(n)
+  return n, err
+}
+
+// Close closes the current log file.
+//
+// It returns any error encountered.
+func (r *RotatingFile) Close() error {
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+  if r.file == nil {
+    return nil
+  }
+  err := r.file.Close()
+  r.file = nil
+  return err
+}
+
+// open opens the current log file, and reads its size.
+func (r *RotatingFile) open() error {
+  file, err := os.OpenFile(r.path, os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+      0644)
+  if err != nil {
+    return err
+  }
+  fileInfo, err := file.Stat()
+  if err != nil {
+    file.Close()
+    return err
+  }
+  r.file = file
+  r.size = fileInfo.Size()
+  return nil
+}
+
+// rotate moves the current log file out of the way, and starts a new file.
+//
+// If closing the current file or a rename fails, for example because another
+// process has one of the files open on Windows, the current file is reopened
+// before the error is returned, so logging goes on in the oversized file.
+func (r *RotatingFile) rotate() error {
+  // NOTE: Windows can't rename a file over an open file, so the current file
+  //       is closed before the renames. The file can't be used after Close,
+  //       even if Close fails.
+  err := r.file.Close()
+  r.file = nil
+  if err == nil {
+    err = r.renameFiles()
+  }
+  if err != nil {
+    if openErr := r.open(); openErr != nil {
+      return openErr
+    }
+    return err
+  }
+  return r.open()
+}
+
+// renameFiles shifts the current and old log files to the next backup path.
+func (r *RotatingFile) renameFiles() error {
+  for i := logFileBackups - 1; i >= 1; i -= 1 {
+    err := os.Rename(r.backupPath(i), r.backupPath(i + 1))
+    if err != nil && !os.IsNotExist(err) {
+      return err
+    }
+  }
+  return os.Rename(r.path, r.backupPath(1))
+}
+
+// backupPath returns the path of an old log file.
+//
+// Larger indexes point to older files.
+func (r *RotatingFile) backupPath(index int) string {
+  return fmt.Sprintf("%s.%d", r.path, index)
+}
+
+// discardLogger returns a logger that drops all its records.
+//
+// Reporter components log to it until they are given a logger.
+func discardLogger() *slog.Logger {
+  return slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+}
@@ -0,0 +1,179 @@
+package reporter
+
+import (
+  "encoding/binary"
+  "errors"
+  "time"
+)
+
+// LogLine is a line read from one of Hearthstone's logging output files.
+type LogLine struct {
+  // The name of the log that the line came from, such as "game" or "net".
+  Source string
+  // The line's byte offset in its log file.
+  Offset int64
+  // The time when the reporter read the line.
+  Time time.Time
+  // The line's contents, including the trailing newline.
+  Data []byte
+  // The ID of the game that the line was written during. Empty for lines
+  // written outside games, such as the network log's lines.
+  GameId string
+  // Set on the lines that start and end games.
+  GameBoundary GameBoundary
+
+  // True if the line does not directly follow the previous line reported from
+  // the same log, because the watcher skipped the log's existing data, or
+  // because the log was truncated.
+  gap bool
+  // The watcher that read the line, or nil if the line was not read by a
+  // LogWatcher.
+  watcher *LogWatcher
+  // The line's position among the lines reported by its watcher.
+  sequence int64
+  // The offset right after the line in its log file.
+  endOffset int64
+}
+
+// markSpooled tells the watcher that read the line that it was spooled.
+//
+// The watcher's checkpoint only covers the lines that were spooled, so a
+// reporter that dies never skips the lines that it read but didn't spool.
+func (l *LogLine) markSpooled() {
+  if l.watcher != nil {
+    l.watcher.lineSpooled(l.sequence, l.endOffset)
+  }
+}
+
+// GameBoundary marks the log lines that start and end games.
+type GameBoundary int
+
+const (
+  // The line neither starts nor ends a game.
+  NoGameBoundary GameBoundary = iota
+  // The line starts a game.
+  GameStarted
+  // The line ends a game that the reporter saw from its start.
+  GameCompleted
+  // The line ends a game whose start the reporter missed.
+  GameTruncated
+)
+
+// String returns the boundary's name in the upload protocol.
+func (b GameBoundary) String() string {
+  switch b {
+  case GameStarted:
+    return "start"
+  case GameCompleted:
+    return "complete"
+  case GameTruncated:
+    return "truncated"
+  }
+  return ""
+}
+
+// The first byte of a spool entry holding an encoded LogLine.
+//
+// Text never starts with this byte, so entries written by reporters that
+// spooled raw log lines can be told apart.
+const logLineSpoolTag = 0x01
+
+// The first byte of a spool entry holding a LogLine with game information.
+const gameLineSpoolTag = 0x02
+
+// encodeSpoolEntry serializes a log line so it can be stored in a spool.
+func encodeSpoolEntry(line LogLine) []byte {
+  entry := make([]byte, 0, 2 + 4 * binary.MaxVarintLen64 +
+      len(line.Source) + len(line.GameId) + len(line.Data))
+  hasGame := line.GameId != "" || line.GameBoundary != NoGameBoundary
+  if hasGame {
+    entry = append(entry, gameLineSpoolTag)
+  } else {
+    entry = append(entry, logLineSpoolTag)
+  }
+  entry = appendUvarint(entry, uint64(len(line.Source)))
+  entry = append(entry, line.Source...)
+  entry = appendUvarint(entry, uint64(line.Offset))
+  // NOTE: Lines without a read time, such as backfilled lines, are stored
+  //       with a zero time stamp.
+  unixNano := uint64(0)
+  if !line.Time.IsZero() {
+    unixNano = uint64(line.Time.UnixNano())
+  }
+  entry = appendUvarint(entry, unixNano)
+  if hasGame {
+    entry = appendUvarint(entry, uint64(len(line.GameId)))
+    entry = append(entry, line.GameId...)
+    entry = append(entry, byte(line.GameBoundary))
+  }
+  return append(entry, line.Data...)
+}
+
+// decodeSpoolEntry deserializes a log line stored in a spool.
+func decodeSpoolEntry(entry []byte) (LogLine, error) {
+  if len(entry) == 0 ||
+      (entry[0] != logLineSpoolTag && entry[0] != gameLineSpoolTag) {
+    // Spooled by a reporter that only stored the raw log line.
+    return LogLine{Offset: -1, Data: entry}, nil
+  }
+  hasGame := entry[0] == gameLineSpoolTag
+  entry = entry[1:]
+
+  sourceSize, entry, err := readUvarint(entry)
+  if err != nil {
+    return LogLine{}, err
+  }
+  if uint64(len(entry)) < sourceSize {
+    return LogLine{}, errInvalidSpoolEntry
+  }
+  line := LogLine{Source: string(entry[:sourceSize])}
+  entry = entry[sourceSize:]
+
+  offset, entry, err := readUvarint(entry)
+  if err != nil {
+    return LogLine{}, err
+  }
+  line.Offset = int64(offset)
+  unixNano, entry, err := readUvarint(entry)
+  if err != nil {
+    return LogLine{}, err
+  }
+  if unixNano != 0 {
+    line.Time = time.Unix(0, int64(unixNano))
+  }
+  if hasGame {
+    gameIdSize, rest, err := readUvarint(entry)
+    if err != nil {
+      return LogLine{}, err
+    }
+    if uint64(len(rest)) < gameIdSize + 1 {
+      return LogLine{}, errInvalidSpoolEntry
+    }
+    line.GameId = string(rest[:gameIdSize])
+    line.GameBoundary = GameBoundary(rest[gameIdSize])
+    entry = rest[gameIdSize + 1:]
+  }
+  line.Data = entry
+  return line, nil
+}
+
+// The error returned when decoding a malformed spool entry.
+var errInvalidSpoolEntry = errors.New("Invalid log line in spool")
+
+// appendUvarint appends the varint encoding of a number to a buffer.
+func appendUvarint(buffer []byte, value uint64) []byte {
+  var encoded [binary.MaxVarintLen64]byte
+  size := binary.PutUvarint(encoded[:], value)
+  return append(buffer, encoded[:size]...)
+}
+
+// readUvarint decodes a varint at the beginning of a buffer.
+//
+// It returns the decoded number and the rest of the buffer.
+func readUvarint(buffer []byte) (uint64, []byte, error) {
+  value, size := binary.Uvarint(buffer)
+  if size <= 0 {
+    return 0, nil, errInvalidSpoolEntry
+  }
+  return value, buffer[size:], nil
+}
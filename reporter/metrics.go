@@ -0,0 +1,178 @@
+package reporter
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// The upper bounds of the POST latency histogram's buckets, in seconds.
+var LatencyBuckets = [...]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// LatencyHistogram counts requests by how long they took.
+type LatencyHistogram struct {
+  // Counts[i] is the number of requests that took longer than
+  // LatencyBuckets[i - 1] seconds, and at most LatencyBuckets[i] seconds.
+  // The last count is for the requests slower than all the buckets.
+  Counts [len(LatencyBuckets) + 1]int64
+  // The number of requests.
+  Count int64
+  // The total time taken by the requests.
+  Sum time.Duration
+}
+
+// Observe records a request's duration.
+func (h *LatencyHistogram) Observe(duration time.Duration) {
+  seconds := duration.Seconds()
+  bucket := sort.SearchFloat64s(LatencyBuckets[:], seconds)
+  h.Counts[bucket] += 1
+  h.Count += 1
+  h.Sum += duration
+}
+
+// WriteMetrics writes the reporter's counters in Prometheus' text format.
+//
+// It returns any error encountered.
+func (s *State) WriteMetrics(writer io.Writer) error {
+  uploadStats := s.Uploader.Stats()
+  lineStats := make(map[string]*WatchStats)
+  for _, stats := range s.Watchers.Stats() {
+    sourceStats := lineStats[stats.Source]
+    if sourceStats == nil {
+      sourceStats = &WatchStats{}
+      lineStats[stats.Source] = sourceStats
+    }
+    sourceStats.LinesRead += stats.LinesRead
+    sourceStats.LinesFiltered += stats.LinesFiltered
+  }
+  for source := range uploadStats.SourceLines {
+    if lineStats[source] == nil {
+      lineStats[source] = &WatchStats{}
+    }
+  }
+  sources := make([]string, 0, len(lineStats))
+  for source := range lineStats {
+    sources = append(sources, source)
+  }
+  sort.Strings(sources)
+
+  metrics := metricsWriter{writer: bufio.NewWriter(writer)}
+  metrics.header("hsreporter_lines_read_total", "counter",
+      "Log lines read from each source.")
+  for _, source := range sources {
+    metrics.sample("hsreporter_lines_read_total", sourceLabel(source),
+        float64(lineStats[source].LinesRead))
+  }
+  metrics.header("hsreporter_lines_filtered_total", "counter",
+      "Log lines read from each source and not uploaded.")
+  for _, source := range sources {
+    metrics.sample("hsreporter_lines_filtered_total", sourceLabel(source),
+        float64(lineStats[source].LinesFiltered))
+  }
+  metrics.header("hsreporter_lines_uploaded_total", "counter",
+      "Log lines from each source accepted by the server.")
+  for _, source := range sources {
+    metrics.sample("hsreporter_lines_uploaded_total", sourceLabel(source),
+        float64(uploadStats.SourceLines[source]))
+  }
+
+  metrics.counter("hsreporter_batches_uploaded_total",
+      "POST requests accepted by the server.", uploadStats.Batches)
+  metrics.counter("hsreporter_uploaded_bytes_total",
+      "Log bytes accepted by the server, before compression.",
+      uploadStats.RawBytes)
+  metrics.counter("hsreporter_post_retries_total",
+      "POST requests that failed and were retried.", uploadStats.Retries)
+  metrics.counter("hsreporter_rejected_batches_total",
+      "POST requests refused by the server for good, such as requests with " +
+      "an invalid token.", uploadStats.RejectedBatches)
+  metrics.counter("hsreporter_dropped_lines_total",
+      "Log lines that could not be queued for uploading, or read back " +
+      "from the queue.", uploadStats.DroppedLines)
+  metrics.header("hsreporter_queued_bytes", "gauge",
+      "Log bytes queued for uploading.")
+  metrics.sample("hsreporter_queued_bytes", "",
+      float64(s.Spool.PendingBytes()))
+  metrics.header("hsreporter_last_post_timestamp_seconds", "gauge",
+      "The time when the server last accepted a POST request.")
+  lastPost := 0.0
+  if !uploadStats.LastPost.IsZero() {
+    lastPost = float64(uploadStats.LastPost.UnixNano()) / 1e9
+  }
+  metrics.sample("hsreporter_last_post_timestamp_seconds", "", lastPost)
+
+  latency := uploadStats.PostLatency
+  metrics.header("hsreporter_post_duration_seconds", "histogram",
+      "The time taken by POST requests that got a response.")
+  var cumulativeCount int64
+  for i, bound := range LatencyBuckets {
+    cumulativeCount += latency.Counts[i]
+    metrics.sample("hsreporter_post_duration_seconds_bucket",
+        `le="` + formatMetric(bound) + `"`, float64(cumulativeCount))
+  }
+  metrics.sample("hsreporter_post_duration_seconds_bucket", `le="+Inf"`,
+      float64(latency.Count))
+  metrics.sample("hsreporter_post_duration_seconds_sum", "",
+      latency.Sum.Seconds())
+  metrics.sample("hsreporter_post_duration_seconds_count", "",
+      float64(latency.Count))
+
+  if metrics.err != nil {
+    return metrics.err
+  }
+  return metrics.writer.Flush()
+}
+
+// metricsWriter writes metrics in Prometheus' text format.
+//
+// It remembers the first error encountered, and skips the writes that come
+// after it.
+type metricsWriter struct {
+  writer *bufio.Writer
+  err error
+}
+
+// header writes the HELP and TYPE lines that precede a metric's samples.
+func (m *metricsWriter) header(name string, metricType string, help string) {
+  m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes one of a metric's samples.
+//
+// The labels are already formatted, such as `source="game"`.
+func (m *metricsWriter) sample(name string, labels string, value float64) {
+  if labels != "" {
+    name += "{" + labels + "}"
+  }
+  m.printf("%s %s\n", name, formatMetric(value))
+}
+
+// counter writes a counter that has a single sample.
+func (m *metricsWriter) counter(name string, help string, value int64) {
+  m.header(name, "counter", help)
+  m.sample(name, "", float64(value))
+}
+
+// printf writes formatted text, unless a previous write failed.
+func (m *metricsWriter) printf(format string, args ...interface{}) {
+  if m.err == nil {
+    _, m.err = fmt.Fprintf(m.writer, format, args...)
+  }
+}
+
+// Escapes the special characters in Prometheus label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sourceLabel formats the label that identifies a log source.
+func sourceLabel(source string) string {
+  return `source="` + labelEscaper.Replace(source) + `"`
+}
+
+// formatMetric formats a sample value or a bucket bound.
+func formatMetric(value float64) string {
+  return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -52,6 +52,29 @@ func DefaultGameLogFile() string {
   return ""
 }
 
+// DefaultLogsDir returns the path to Hearthstone's per-category log files.
+//
+// It returns the expected directory path, assuming a standard game
+// installation.
+func DefaultLogsDir() string {
+  // Windows attempts.
+  for _, programDir := range []string{"Program Files (x86)", "Program Files"} {
+    installDir := filepath.Join("C:", programDir, "Hearthstone")
+    if _, err := os.Stat(installDir); err == nil {
+      return filepath.Join(installDir, "Logs")
+    }
+  }
+
+  // OSX attempt.
+  appDir := "/Applications/Hearthstone"
+  if _, err := os.Stat(appDir); err == nil {
+    return filepath.Join(appDir, "Logs")
+  }
+
+  // Failed to find a default path.
+  return ""
+}
+
 // DefaultNetLogFile returns the path to Hearthstone's network logging file.
 //
 // It returns the expected file path, assuming a standard game installation.
@@ -73,3 +96,22 @@ func DefaultNetLogFile() string {
   // Failed to find a default path.
   return ""
 }
+
+// DefaultStateDir returns the path to the reporter's own state directory.
+//
+// The directory holds data that must survive reporter restarts, such as the
+// logging output that hasn't been uploaded yet.
+func DefaultStateDir() string {
+  // OSX and Linux attempt.
+  if homeDir := os.Getenv("HOME"); homeDir != "" {
+    return filepath.Join(homeDir, ".hsreporter")
+  }
+
+  // Windows attempt.
+  if userProfile := os.Getenv("USERPROFILE"); userProfile != "" {
+    return filepath.Join(userProfile, ".hsreporter")
+  }
+
+  // Fall back to the current directory.
+  return ".hsreporter"
+}
@@ -0,0 +1,81 @@
+package reporter
+
+// LineProcessor transforms the log lines on their way to the uploader.
+//
+// Processors are called on a single goroutine, in the order in which the log
+// lines were read.
+type LineProcessor interface {
+  // ProcessLine returns the transformed line.
+  //
+  // The boolean is false if the line should not be uploaded.
+  ProcessLine(line LogLine) (LogLine, bool)
+}
+
+// Pipeline runs the log lines produced by the watchers through a sequence of
+// processors, before handing them to the uploader.
+type Pipeline struct {
+  // Source for the lines produced by the watchers.
+  input chan LogLine
+  // Sink for the processed lines.
+  output chan<- LogLine
+  // The processors applied to each line, in order.
+  processors []LineProcessor
+}
+
+// Init sets up the pipeline's initial state.
+//
+// The processed lines are sent to the output channel.
+func (p *Pipeline) Init(output chan<- LogLine) {
+  p.input = make(chan LogLine, 1024)
+  p.output = output
+  p.processors = nil
+}
+
+// Add appends a processor to the pipeline.
+//
+// It must be called before Start.
+func (p *Pipeline) Add(processor LineProcessor) {
+  p.processors = append(p.processors, processor)
+}
+
+// Input returns the channel that receives the lines to be processed.
+func (p *Pipeline) Input() chan<- LogLine {
+  return p.input
+}
+
+// Start spawns the goroutine that processes log lines.
+func (p *Pipeline) Start() {
+  go p.processLoop()
+}
+
+// Close tells the pipeline that no more lines will be sent to its input.
+//
+// The lines that were already sent are processed, then the output channel is
+// closed. Close must only be called after all the watchers stopped, because
+// sending a line after Close panics.
+func (p *Pipeline) Close() {
+  close(p.input)
+}
+
+// Process runs a line through all the pipeline's processors.
+//
+// The boolean is false if a processor dropped the line.
+func (p *Pipeline) Process(line LogLine) (LogLine, bool) {
+  for _, processor := range p.processors {
+    var ok bool
+    if line, ok = processor.ProcessLine(line); !ok {
+      return line, false
+    }
+  }
+  return line, true
+}
+
+// processLoop repeatedly processes lines and passes them on.
+func (p *Pipeline) processLoop() {
+  for line := range p.input {
+    if processedLine, ok := p.Process(line); ok {
+      p.output <- processedLine
+    }
+  }
+  close(p.output)
+}
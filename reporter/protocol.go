@@ -0,0 +1,83 @@
+package reporter
+
+import (
+  "bytes"
+  "encoding/json"
+  "time"
+)
+
+// The highest protocol version implemented by the reporter.
+const maxProtocolVersion = 2
+
+// protocolRecord is the JSON representation of a log line in protocol 2.
+type protocolRecord struct {
+  // The name of the log that the line came from, such as "game" or "net".
+  Source string `json:"source"`
+  // The line's byte offset in its log file, or -1 if unknown.
+  Offset int64 `json:"offset"`
+  // The time when the reporter read the line, in RFC 3339 format.
+  Time string `json:"time,omitempty"`
+  // The line's contents, without the trailing newline.
+  Line string `json:"line"`
+  // The ID of the game that the line was written during, if any.
+  Game string `json:"game,omitempty"`
+  // Set to "start", "complete" or "truncated" on the lines that start and
+  // end games.
+  GameEvent string `json:"gameEvent,omitempty"`
+}
+
+// negotiateProtocol picks the protocol version used for uploads.
+//
+// Servers that predate protocol negotiation don't state a version, and get
+// protocol 1.
+func negotiateProtocol(serverProto int) int {
+  if serverProto > maxProtocolVersion {
+    return maxProtocolVersion
+  }
+  if serverProto < 1 {
+    return 1
+  }
+  return serverProto
+}
+
+// protocolContentType returns the Content-Type of a request body.
+func protocolContentType(proto int) string {
+  if proto >= 2 {
+    return "application/x-ndjson"
+  }
+  return "application/octet-stream"
+}
+
+// encodeBatchBody builds a request body carrying some log lines.
+//
+// Protocol 1 bodies are the raw log lines. Protocol 2 bodies have one JSON
+// record per log line, and each record ends with a newline.
+func encodeBatchBody(lines []LogLine, proto int) ([]byte, error) {
+  var body bytes.Buffer
+  if proto < 2 {
+    for _, line := range lines {
+      body.Write(line.Data)
+    }
+    return body.Bytes(), nil
+  }
+
+  // NOTE: json.Encoder terminates each value with a newline.
+  encoder := json.NewEncoder(&body)
+  encoder.SetEscapeHTML(false)
+  for _, line := range lines {
+    record := protocolRecord{
+      Source: line.Source,
+      Offset: line.Offset,
+      Line: string(bytes.TrimRight(line.Data, "\r\n")),
+      Game: line.GameId,
+      GameEvent: line.GameBoundary.String(),
+    }
+    if !line.Time.IsZero() {
+      record.Time = line.Time.UTC().Format(time.RFC3339Nano)
+    }
+    if err := encoder.Encode(&record); err != nil {
+      return nil, err
+    }
+  }
+  return body.Bytes(), nil
+}
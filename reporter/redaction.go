@@ -0,0 +1,352 @@
+package reporter
+
+import (
+  "bufio"
+  "bytes"
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/binary"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strconv"
+  "strings"
+)
+
+// RedactionMode says how a kind of personal information is redacted.
+//
+// RedactionMode implements flag.Value, so it can be set by command-line flags.
+type RedactionMode string
+
+const (
+  // The information is uploaded unchanged.
+  RedactionKeep RedactionMode = "keep"
+  // The information is replaced by a value derived from a keyed hash.
+  //
+  // Equal values get equal replacements, so the server can still tell the
+  // players apart. The hash key is unique to each user, so the server can't
+  // reverse the hash by trying all possible values.
+  RedactionHash RedactionMode = "hash"
+  // The information is replaced by a fixed placeholder.
+  RedactionMask RedactionMode = "mask"
+)
+
+// String implements flag.Value.
+func (m *RedactionMode) String() string {
+  return string(*m)
+}
+
+// Set implements flag.Value.
+func (m *RedactionMode) Set(value string) error {
+  switch mode := RedactionMode(value); mode {
+  case RedactionKeep, RedactionHash, RedactionMask:
+    *m = mode
+    return nil
+  }
+  return fmt.Errorf("Invalid redaction mode %q (use keep, hash or mask)",
+      value)
+}
+
+// RedactionConfig says how each kind of personal information is redacted.
+//
+// Empty modes are treated as RedactionKeep.
+type RedactionConfig struct {
+  // Player names, such as "Name#1234", in the game log.
+  BattleTags RedactionMode
+  // The hi and lo parts of players' account IDs, in the game log.
+  AccountIds RedactionMode
+  // IPv4 and IPv6 addresses, such as the game server's address in the
+  // network log.
+  IPs RedactionMode
+}
+
+// Enabled returns true if any information is redacted.
+func (c RedactionConfig) Enabled() bool {
+  return redactionActive(c.BattleTags) || redactionActive(c.AccountIds) ||
+      redactionActive(c.IPs)
+}
+
+// redactionActive returns true if a mode changes the redacted information.
+func redactionActive(mode RedactionMode) bool {
+  return mode != "" && mode != RedactionKeep
+}
+
+// Matches BattleTags, such as "Name#1234".
+//
+// Go's \b only understands ASCII, so the start of the name is matched
+// explicitly, to support non-English names.
+var battleTagPattern = regexp.MustCompile(
+    `(?:^|[^\pL\pM\pN])([\pL\pM\pN]{2,12}#\d{4,6})`)
+
+// Matches account IDs, such as "hi=144115193835963207 lo=23761013".
+var accountIdPattern = regexp.MustCompile(`\bhi=(\d+) lo=(\d+)`)
+
+// Matches IPv4 addresses, such as "12.34.56.78".
+var ipPattern = regexp.MustCompile(
+    `(?:^|[^\d.])(\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3})`)
+
+// Matches text that may be an IPv6 address, such as "2001:db8::1".
+//
+// The pattern also matches other text, such as time stamps, so the matches
+// are validated before they are redacted.
+var ipv6Pattern = regexp.MustCompile(
+    `(?:^|[^\w:.])([0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}` +
+    `(?:\.\d{1,3}){0,3})`)
+
+// Redactor removes personal information from log lines before they are
+// uploaded.
+//
+// Redactor implements LineProcessor.
+type Redactor struct {
+  // Says how each kind of information is redacted.
+  config RedactionConfig
+  // The key for the hashes used by RedactionHash.
+  salt []byte
+}
+
+// RedactionSaltFile returns the path to the key used for hashing information.
+func RedactionSaltFile(stateDir string) string {
+  return filepath.Join(stateDir, "redaction.salt")
+}
+
+// Init sets up the redactor's initial state.
+//
+// The hash key is read from the state directory. If the key does not exist, a
+// random key is generated and saved. It returns any error encountered.
+func (r *Redactor) Init(config RedactionConfig, stateDir string) error {
+  r.config = config
+  if !config.Enabled() {
+    return nil
+  }
+
+  saltFile := RedactionSaltFile(stateDir)
+  salt, err := ioutil.ReadFile(saltFile)
+  if err == nil {
+    r.salt, err = hex.DecodeString(strings.TrimSpace(string(salt)))
+    return err
+  }
+  if !os.IsNotExist(err) {
+    return err
+  }
+
+  r.salt = make([]byte, 32)
+  if _, err := rand.Read(r.salt); err != nil {
+    return err
+  }
+  if err := os.MkdirAll(stateDir, 0755); err != nil {
+    return err
+  }
+  // NOTE: Anyone who has the key can reverse the hashes, by trying all the
+  //       plausible values. So, the key is only readable by its owner.
+  return writeFileAtomically(saltFile, []byte(hex.EncodeToString(r.salt)),
+      0600)
+}
+
+// ProcessLine implements LineProcessor.
+func (r *Redactor) ProcessLine(line LogLine) (LogLine, bool) {
+  line.Data = r.Redact(line.Data)
+  return line, true
+}
+
+// Redact removes personal information from a log line.
+//
+// If the line holds personal information, the redacted line is a copy.
+// Otherwise, the line is returned unchanged.
+func (r *Redactor) Redact(data []byte) []byte {
+  if redactionActive(r.config.AccountIds) {
+    data = replaceGroups(data, accountIdPattern, func(value []byte) []byte {
+      return r.redactAccountId(value)
+    })
+  }
+  if redactionActive(r.config.BattleTags) {
+    data = replaceGroups(data, battleTagPattern, func(value []byte) []byte {
+      return r.redactBattleTag(value)
+    })
+  }
+  if redactionActive(r.config.IPs) {
+    // NOTE: IPv6 addresses are redacted first, so the IPv4 address at the
+    //       end of an address such as "::ffff:12.34.56.78" isn't redacted on
+    //       its own.
+    data = replaceGroups(data, ipv6Pattern, func(value []byte) []byte {
+      return r.redactIPv6(value)
+    })
+    data = replaceGroups(data, ipPattern, func(value []byte) []byte {
+      return r.redactIP(value)
+    })
+  }
+  return data
+}
+
+// redactBattleTag replaces a "Name#1234" BattleTag.
+func (r *Redactor) redactBattleTag(battleTag []byte) []byte {
+  if r.config.BattleTags == RedactionMask {
+    return []byte("REDACTED#0000")
+  }
+  // The replacement looks like a BattleTag, so the server's parser can still
+  // recognize it.
+  digest := r.hash("battletag", battleTag)
+  return []byte(fmt.Sprintf("P%s#%04d", hex.EncodeToString(digest[:5]),
+      1000 + binary.BigEndian.Uint16(digest[5:]) % 9000))
+}
+
+// redactAccountId replaces a hi or lo part of an account ID.
+func (r *Redactor) redactAccountId(id []byte) []byte {
+  if r.config.AccountIds == RedactionMask {
+    return []byte("0")
+  }
+  // The replacement is a number that fits in an int64.
+  digest := r.hash("account", id)
+  value := binary.BigEndian.Uint64(digest) & 0x7fffffffffffffff
+  return []byte(strconv.FormatUint(value, 10))
+}
+
+// redactIP replaces an IPv4 address.
+//
+// It returns the text unchanged if it isn't a valid address, such as a
+// version number.
+func (r *Redactor) redactIP(ip []byte) []byte {
+  for _, octet := range bytes.Split(ip, []byte(".")) {
+    if value, err := strconv.Atoi(string(octet)); err != nil || value > 255 {
+      return ip
+    }
+  }
+  if r.config.IPs == RedactionMask {
+    return []byte("0.0.0.0")
+  }
+  // The replacement is in a private network range, so it is obviously fake.
+  digest := r.hash("ip", ip)
+  return []byte(fmt.Sprintf("10.%d.%d.%d", digest[0], digest[1], digest[2]))
+}
+
+// redactIPv6 replaces an IPv6 address.
+//
+// It returns the text unchanged if it isn't a valid address, such as a time
+// stamp.
+func (r *Redactor) redactIPv6(ip []byte) []byte {
+  if net.ParseIP(string(ip)) == nil {
+    return ip
+  }
+  if r.config.IPs == RedactionMask {
+    return []byte("::")
+  }
+  // The replacement is a unique local address, so it is obviously fake.
+  digest := r.hash("ip", ip)
+  return []byte(fmt.Sprintf("fd%02x:%02x%02x:%02x%02x::1", digest[0],
+      digest[1], digest[2], digest[3], digest[4]))
+}
+
+// hash computes the keyed hash for a value.
+//
+// The kind of value is hashed together with the value, so the same text gets
+// different replacements in different contexts.
+func (r *Redactor) hash(kind string, value []byte) []byte {
+  mac := hmac.New(sha256.New, r.salt)
+  mac.Write([]byte(kind))
+  mac.Write([]byte{0})
+  mac.Write(value)
+  return mac.Sum(nil)
+}
+
+// replaceGroups replaces the capture groups in all the matches of a pattern.
+//
+// The text outside the capture groups is kept. The data is only copied if it
+// contains a match.
+func replaceGroups(data []byte, pattern *regexp.Regexp,
+    replace func([]byte) []byte) []byte {
+  matches := pattern.FindAllSubmatchIndex(data, -1)
+  if len(matches) == 0 {
+    return data
+  }
+
+  result := make([]byte, 0, len(data))
+  copied := 0
+  for _, match := range matches {
+    for group := 2; group < len(match); group += 2 {
+      start, end := match[group], match[group + 1]
+      if start == -1 {
+        continue
+      }
+      result = append(result, data[copied:start]...)
+      result = append(result, replace(data[start:end])...)
+      copied = end
+    }
+  }
+  return append(result, data[copied:]...)
+}
+
+// PrintRedactions shows the changes that redaction makes to existing logs.
+//
+// The game and network logs in the configuration are read and redacted, and
+// every changed line is written to the output, before and after redaction.
+// It returns any error encountered.
+func PrintRedactions(config Config, output io.Writer) error {
+  var redactor Redactor
+  if err := redactor.Init(config.Redaction, config.StateDir); err != nil {
+    return err
+  }
+
+  var logFiles []string
+  if config.LogsDir != "" {
+    sessionDir, err := findSessionDir(config.LogsDir)
+    if err != nil {
+      return err
+    }
+    if sessionDir != "" {
+      matches, err := filepath.Glob(filepath.Join(sessionDir, "*.log"))
+      if err != nil {
+        return err
+      }
+      logFiles = append(logFiles, matches...)
+    }
+  } else {
+    logFiles = append(logFiles, config.GameLogFile)
+  }
+  logFiles = append(logFiles, config.NetLogFile)
+
+  changedLines := 0
+  for _, logFile := range logFiles {
+    count, err := printFileRedactions(&redactor, logFile, output)
+    if err != nil {
+      return err
+    }
+    changedLines += count
+  }
+  fmt.Fprintf(output, "%d lines would be redacted.\n", changedLines)
+  return nil
+}
+
+// printFileRedactions shows the changes that redaction makes to a log file.
+//
+// It returns the number of changed lines. Missing files are skipped.
+func printFileRedactions(redactor *Redactor, logFile string,
+    output io.Writer) (int, error) {
+  file, err := os.Open(logFile)
+  if os.IsNotExist(err) {
+    return 0, nil
+  }
+  if err != nil {
+    return 0, err
+  }
+  defer file.Close()
+
+  changedLines := 0
+  scanner := bufio.NewScanner(file)
+  scanner.Buffer(make([]byte, 64 * 1024), 16 * 1024 * 1024)
+  for lineNumber := 1; scanner.Scan(); lineNumber += 1 {
+    line := bytes.TrimSuffix(scanner.Bytes(), []byte("\r"))
+    redacted := redactor.Redact(line)
+    if bytes.Equal(line, redacted) {
+      continue
+    }
+    changedLines += 1
+    fmt.Fprintf(output, "%s:%d\n- %s\n+ %s\n", logFile, lineNumber, line,
+        redacted)
+  }
+  return changedLines, scanner.Err()
+}
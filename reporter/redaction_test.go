@@ -0,0 +1,129 @@
+package reporter
+
+import (
+  "regexp"
+  "testing"
+)
+
+func TestRedactorRedact(t *testing.T) {
+  keep := RedactionConfig{}
+  hash := RedactionConfig{
+    BattleTags: RedactionHash,
+    AccountIds: RedactionHash,
+    IPs: RedactionHash,
+  }
+  mask := RedactionConfig{
+    BattleTags: RedactionMask,
+    AccountIds: RedactionMask,
+    IPs: RedactionMask,
+  }
+  tests := []struct {
+    config RedactionConfig
+    line string
+    // A regular expression that must match the whole redacted line.
+    want string
+  }{
+    // BattleTags.
+    {keep, "PlayerID=1, PlayerName=Alice#1234",
+        `PlayerID=1, PlayerName=Alice#1234`},
+    {mask, "PlayerID=1, PlayerName=Alice#1234",
+        `PlayerID=1, PlayerName=REDACTED#0000`},
+    {hash, "PlayerID=1, PlayerName=Alice#1234",
+        `PlayerID=1, PlayerName=P[0-9a-f]{10}#\d{4}`},
+    {mask, "TAG_CHANGE Entity=Ünïcödé#12345 tag=PLAYSTATE value=WON",
+        `TAG_CHANGE Entity=REDACTED#0000 tag=PLAYSTATE value=WON`},
+    {mask, "TAG_CHANGE Entity=플레이어#3456 tag=CURRENT_PLAYER value=1",
+        `TAG_CHANGE Entity=REDACTED#0000 tag=CURRENT_PLAYER value=1`},
+    {hash, "PlayerName=Ünïcödé#12345",
+        `PlayerName=P[0-9a-f]{10}#\d{4}`},
+    // Names with spaces are card and AI names, not BattleTags.
+    {mask, "Entity=[entityName=Leeroy Jenkins id=4 zone=HAND player=1]",
+        `Entity=\[entityName=Leeroy Jenkins id=4 zone=HAND player=1\]`},
+    {mask, "PlayerID=2, PlayerName=The Innkeeper",
+        `PlayerID=2, PlayerName=The Innkeeper`},
+    {mask, "Name#123 A#1234", `Name#123 A#1234`},
+
+    // Account IDs.
+    {keep, "GameAccountId=[hi=144115193835963207 lo=30722021]",
+        `GameAccountId=\[hi=144115193835963207 lo=30722021\]`},
+    {mask, "GameAccountId=[hi=144115193835963207 lo=30722021]",
+        `GameAccountId=\[hi=0 lo=0\]`},
+    {hash, "GameAccountId=[hi=144115193835963207 lo=30722021]",
+        `GameAccountId=\[hi=\d{1,19} lo=\d{1,19}\]`},
+
+    // IP addresses.
+    {keep, "GotoGameServer address= 12.34.56.78:3724",
+        `GotoGameServer address= 12\.34\.56\.78:3724`},
+    {mask, "GotoGameServer address= 12.34.56.78:3724",
+        `GotoGameServer address= 0\.0\.0\.0:3724`},
+    {hash, "GotoGameServer address= 12.34.56.78:3724",
+        `GotoGameServer address= 10\.\d{1,3}\.\d{1,3}\.\d{1,3}:3724`},
+    {mask, "Client version 10.0.300.1", `Client version 10\.0\.300\.1`},
+    {keep, "GotoGameServer address=[2001:db8::1]:3724",
+        `GotoGameServer address=\[2001:db8::1\]:3724`},
+    {mask, "GotoGameServer address=[2001:db8::1]:3724",
+        `GotoGameServer address=\[::\]:3724`},
+    {hash, "GotoGameServer address=[2001:db8::1]:3724",
+        `GotoGameServer address=\[fd[0-9a-f]{2}(:[0-9a-f]{4}){2}::1\]:3724`},
+    {mask, "address=2001:0db8:85a3:0000:0000:8a2e:0370:7334 port=3724",
+        `address=:: port=3724`},
+    {mask, "address=::ffff:12.34.56.78 port=3724", `address=:: port=3724`},
+    // Time stamps and hardware addresses look like IPv6 addresses.
+    {mask, "D 20:15:01.2345678 GameState.DebugPrintPower() - CREATE_GAME",
+        `D 20:15:01\.2345678 GameState\.DebugPrintPower\(\) - CREATE_GAME`},
+    {mask, "Adapter 00:1a:2b:3c:4d:5e", `Adapter 00:1a:2b:3c:4d:5e`},
+
+    // Each kind of information has its own mode.
+    {RedactionConfig{BattleTags: RedactionMask},
+        "Alice#1234 hi=1 lo=2 12.34.56.78",
+        `REDACTED#0000 hi=1 lo=2 12\.34\.56\.78`},
+    {RedactionConfig{IPs: RedactionMask},
+        "Alice#1234 hi=1 lo=2 12.34.56.78",
+        `Alice#1234 hi=1 lo=2 0\.0\.0\.0`},
+  }
+  for _, test := range tests {
+    redactor := &Redactor{config: test.config, salt: []byte("test salt")}
+    redacted := string(redactor.Redact([]byte(test.line)))
+    if !regexp.MustCompile("^" + test.want + "$").MatchString(redacted) {
+      t.Errorf("Redact(%q) with %+v got %q, want %s", test.line,
+          test.config, redacted, test.want)
+    }
+  }
+}
+
+func TestRedactorHashIsConsistent(t *testing.T) {
+  config := RedactionConfig{
+    BattleTags: RedactionHash,
+    AccountIds: RedactionHash,
+    IPs: RedactionHash,
+  }
+  redactor := &Redactor{config: config, salt: []byte("test salt")}
+  otherRedactor := &Redactor{config: config, salt: []byte("other salt")}
+  tests := []struct {
+    value string
+    other string
+  }{
+    {"Alice#1234", "Alice#1235"},
+    {"Ünïcödé#12345", "Unicode#12345"},
+    {"hi=144115193835963207 lo=30722021",
+        "hi=144115193835963207 lo=30722022"},
+    {"12.34.56.78", "12.34.56.79"},
+    {"2001:db8::1", "2001:db8::2"},
+  }
+  for _, test := range tests {
+    first := string(redactor.Redact([]byte(test.value)))
+    second := string(redactor.Redact([]byte(test.value)))
+    if first == test.value || first != second {
+      t.Errorf("%q was redacted to %q and %q", test.value, first, second)
+    }
+    if other := string(redactor.Redact([]byte(test.other))); other == first {
+      t.Errorf("%q and %q were both redacted to %q", test.value, test.other,
+          first)
+    }
+    if salted := string(otherRedactor.Redact([]byte(test.value)));
+        salted == first {
+      t.Errorf("%q was redacted to %q with different salts", test.value,
+          first)
+    }
+  }
+}
@@ -0,0 +1,177 @@
+package reporter
+
+import (
+  "bufio"
+  "github.com/pwnall/hsreporter/parser"
+  "io"
+  "os"
+  "time"
+)
+
+// ReplayStats summarizes a replayed log.
+type ReplayStats struct {
+  // The number of lines written to the target file.
+  Lines int
+  // The number of games started by the replayed lines.
+  Games int
+  // The number of times the target file was truncated.
+  Truncations int
+}
+
+// Replayer writes a recorded game log to a file, the way Hearthstone does.
+//
+// Lines are written one at a time, and the replayer waits between lines as
+// long as Hearthstone did, according to the lines' time stamps. Together with
+// a reporter that watches the target file, the replayer exercises the
+// reporter without needing the game.
+type Replayer struct {
+  // The file that receives the replayed lines.
+  targetFile string
+  // How much faster than Hearthstone the lines are written. 0 means that
+  // lines are written without waiting.
+  speed float64
+  // The longest wait between two lines. 0 means no limit.
+  maxDelay time.Duration
+  // True if the target file is truncated before each game after the first,
+  // as if Hearthstone was restarted between games.
+  restartBetweenGames bool
+  // The file being written.
+  file *os.File
+  // The time stamp of the last replayed line that had one.
+  lastTime time.Duration
+  // True if a replayed line had a time stamp.
+  hasTime bool
+  // Summary of the replayed lines.
+  stats ReplayStats
+}
+
+// Init sets up the replayer's initial state.
+//
+// The speed is a multiplier for the pace at which Hearthstone wrote the
+// recorded log. For example, 4 replays the log 4 times faster. 0 writes the
+// log as fast as possible.
+func (r *Replayer) Init(targetFile string, speed float64) {
+  r.targetFile = targetFile
+  r.speed = speed
+  r.maxDelay = 0
+  r.restartBetweenGames = false
+  r.hasTime = false
+  r.stats = ReplayStats{}
+}
+
+// SetMaxDelay caps the wait between two lines.
+//
+// Recorded logs can have long gaps, such as the time spent in menus between
+// games. A zero delay removes the cap.
+func (r *Replayer) SetMaxDelay(maxDelay time.Duration) {
+  r.maxDelay = maxDelay
+}
+
+// SetRestartBetweenGames makes the replayer simulate game restarts.
+//
+// Hearthstone truncates its log when it starts. If set, the target file is
+// truncated before each game after the first.
+func (r *Replayer) SetRestartBetweenGames(restart bool) {
+  r.restartBetweenGames = restart
+}
+
+// Stats returns a summary of the lines replayed so far.
+func (r *Replayer) Stats() ReplayStats {
+  return r.stats
+}
+
+// Replay writes a recorded log to the target file.
+//
+// The target file is truncated before the first line is written, as
+// Hearthstone does when it starts. Files whose names end in .zst, such as the
+// logs in the game archive, are decompressed. It returns any error
+// encountered.
+func (r *Replayer) Replay(recordedFile string) error {
+  reader, err := openLogFile(recordedFile)
+  if err != nil {
+    return err
+  }
+  defer reader.Close()
+
+  r.file, err = os.OpenFile(r.targetFile,
+      os.O_WRONLY | os.O_CREATE | os.O_TRUNC | os.O_APPEND, 0644)
+  if err != nil {
+    return err
+  }
+  defer r.file.Close()
+
+  bufferedReader := bufio.NewReader(reader)
+  for {
+    data, err := bufferedReader.ReadBytes('\n')
+    if len(data) > 0 {
+      if err := r.replayLine(data); err != nil {
+        return err
+      }
+    }
+    if err == io.EOF {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+  }
+}
+
+// replayLine waits until a line is due, then writes it to the target file.
+func (r *Replayer) replayLine(data []byte) error {
+  line := parser.ParseBytes(data)
+  if line.HasTime {
+    if delay := r.lineDelay(line.Time); delay > 0 {
+      time.Sleep(delay)
+    }
+  }
+
+  // NOTE: The category is not checked, because the lines of a recorded
+  //       Power.log don't have the [Power] marker.
+  if classifyPowerLine(data) == powerGameCreated {
+    if r.stats.Games > 0 && r.restartBetweenGames {
+      if err := r.file.Truncate(0); err != nil {
+        return err
+      }
+      r.stats.Truncations += 1
+    }
+    r.stats.Games += 1
+  }
+
+  // NOTE: The line is written with a single call, so the watcher never sees
+  //       a partial line, unless the recorded log ends with one.
+  if _, err := r.file.Write(data); err != nil {
+    return err
+  }
+  r.stats.Lines += 1
+  return nil
+}
+
+// lineDelay computes the wait before writing a line with a time stamp.
+//
+// Time stamps only have the time of day, so a time stamp that is far earlier
+// than the previous one is assumed to come after midnight. Time stamps that
+// are slightly out of order are written right away.
+func (r *Replayer) lineDelay(lineTime time.Duration) time.Duration {
+  if !r.hasTime {
+    r.hasTime = true
+    r.lastTime = lineTime
+    return 0
+  }
+  elapsed := lineTime - r.lastTime
+  if elapsed < -12 * time.Hour {
+    elapsed += 24 * time.Hour
+  }
+  if elapsed <= 0 {
+    return 0
+  }
+  r.lastTime = lineTime
+  if r.speed <= 0 {
+    return 0
+  }
+  delay := time.Duration(float64(elapsed) / r.speed)
+  if r.maxDelay > 0 && delay > r.maxDelay {
+    delay = r.maxDelay
+  }
+  return delay
+}
@@ -5,29 +5,95 @@
 // real time, as it is written to the file.
 package reporter
 
+import (
+  "context"
+  "log/slog"
+  "path/filepath"
+  "sort"
+  "time"
+)
+
 // Configuration for the log uploader.
 type Config struct {
   // Path to Hearthstone's logging config file.
   ConfigFile string
   // Path to Hearthstone's game logging output file.
   GameLogFile string
+  // Path to Hearthstone's directory of per-category log files.
+  //
+  // If set, the game log is read from the per-category log files in this
+  // directory, instead of GameLogFile.
+  LogsDir string
   // Path to Hearthstone's network logging output file.
   NetLogFile string
   // HTTP endpoint that receives filtered game logging output.
   ServerUrl string
   // Token used to authenticate to the HTTP endpoint.
   ServerToken string
+  // Directory where the reporter keeps its own persistent state.
+  StateDir string
+  // How logging output is grouped into HTTP requests.
+  Batching BatchPolicy
+  // The time between periodic refreshes of the server's config.
+  ConfigRefresh time.Duration
+  // If set, game log lines must match this regular expression to be uploaded.
+  IncludeLines string
+  // If set, game log lines that match this regular expression are not
+  // uploaded.
+  ExcludeLines string
+  // True if game log lines from categories that the server did not ask for
+  // are uploaded.
+  AllCategories bool
+  // Says how personal information is removed from the logs before uploading.
+  Redaction RedactionConfig
+  // If set, each game's log is archived in this directory.
+  ArchiveDir string
+  // The longest time that Run spends uploading the queued logging output
+  // after its context is cancelled. If zero, the queued logging output is
+  // left for the next run.
+  ShutdownTimeout time.Duration
+  // Receives the errors and status changes of a reporter started by Run.
+  Hooks Hooks
+  // Receives the diagnostics of the uploader and the log watchers. If nil,
+  // the diagnostics are dropped.
+  Logger *slog.Logger
+  // If set, the reporter's status and metrics are served over HTTP on this
+  // address, such as "127.0.0.1:8789".
+  StatusAddr string
 }
 
 // The log uploader's state.
 type State struct {
   Config Config
+  // Logging output waiting to be uploaded.
+  Spool Spool
   // HTTP data uploader.
   Uploader Uploader
-  // Game log watcher.
-  GameLogWatcher LogWatcher
-  // Network log watcher.
-  NetLogWatcher LogWatcher
+  // Processes the lines read by the watchers before they are uploaded.
+  Pipeline Pipeline
+  // Assigns the game log's lines to games.
+  GameSplitter GameSplitter
+  // Keeps a copy of each game's log, if the configuration asks for it.
+  GameArchive GameArchive
+  // Removes personal information from the uploaded lines.
+  Redactor Redactor
+  // Serves the reporter's status, if the configuration asks for it.
+  StatusServer StatusServer
+  // Watchers for the game and network logs, and for any sources added by the
+  // caller between Init and Start.
+  Watchers WatcherSet
+  // Game log watcher, used when the game log is split by category.
+  logDirWatcher *LogDirWatcher
+  // Accepts the game log lines from the categories that the server wants.
+  categoryFilter *CategoryFilter
+  // Applies the line filtering requested by the server to the game log.
+  serverFilter DynamicFilter
+  // Accepts the old game log lines that are uploaded by BackfillFile.
+  backfillFilter LineFilter
+  // The logging categories written to Hearthstone's logging config file.
+  categories []string
+  // The server config in effect.
+  serverConfig ServerConfig
 }
 
 // Sets up the logger's state.
@@ -35,44 +101,268 @@ type State struct {
 // It returns any error encountered.
 // The caller must have set up the logger's configuration.
 func (s *State) Init() error {
-  logLines := make(chan []byte, 1024)
-
-  // The game log has a lot of useless lines, and all the useful lines start
-  // with the category marker [, so we use line filtering.
-  err := s.GameLogWatcher.Init(s.Config.GameLogFile, true, logLines)
+  logLines := make(chan LogLine, 1024)
+  s.Pipeline.Init(logLines)
+  err := s.GameSplitter.Init(filepath.Join(s.Config.StateDir, "game.state"))
+  if err != nil {
+    return err
+  }
+  s.Pipeline.Add(&s.GameSplitter)
+  // NOTE: The archive comes before the redactor, so it keeps the games'
+  //       original logs.
+  if s.Config.ArchiveDir != "" {
+    err = s.GameArchive.Init(s.Config.ArchiveDir, s.GameSplitter.GameId())
+    if err != nil {
+      return err
+    }
+    s.Pipeline.Add(&s.GameArchive)
+  }
+  err = s.Redactor.Init(s.Config.Redaction, s.Config.StateDir)
   if err != nil {
     return err
   }
+  if s.Config.Redaction.Enabled() {
+    s.Pipeline.Add(&s.Redactor)
+  }
+  s.Watchers.Init(s.Pipeline.Input())
+  s.Watchers.SetLogger(s.logger())
+
   // The network log has very few lines, and the category marker [ is output
   // after the current date. Filtering would be difficult to implement, and is
-  // unnecessary, so we just upload everything.
-  err = s.NetLogWatcher.Init(s.Config.NetLogFile, false, logLines)
+  // unnecessary, so we just upload everything. The server always needs the
+  // full network log, because its beginning contains region information.
+  err = s.Watchers.AddFile(SourceConfig{
+    Name: "net",
+    Path: s.Config.NetLogFile,
+    ExistingData: ExistingDataAlways,
+    CheckpointFile: filepath.Join(s.Config.StateDir, "net-log.checkpoint"),
+  })
+  if err != nil {
+    return err
+  }
+  gameFilter, err := s.gameLogFilter()
+  if err != nil {
+    return err
+  }
+  // NOTE: The network log watcher must start first, so that we upload region
+  //       information to the server before we start uploading game chunks.
+  if s.UsesLogsDir() {
+    // The per-category log files only contain logging output, so they don't
+    // need the [ prefix filtering.
+    s.logDirWatcher = &LogDirWatcher{}
+    s.logDirWatcher.Init(s.Config.LogsDir, s.Pipeline.Input())
+    s.logDirWatcher.SetLogger(s.logger())
+    s.logDirWatcher.SetFilter(gameFilter)
+    s.logDirWatcher.UseCheckpoints(s.Config.StateDir)
+    err = s.Watchers.Add("game", s.logDirWatcher, ExistingDataIfRequested,
+        []string{"net"})
+  } else {
+    // The game log has a lot of useless lines, and all the useful lines start
+    // with the category marker [, so we use line filtering.
+    err = s.Watchers.AddFile(SourceConfig{
+      Name: "game",
+      Path: s.Config.GameLogFile,
+      Filter: AllFilters(NewPrefixFilter("["), gameFilter),
+      ExistingData: ExistingDataIfRequested,
+      After: []string{"net"},
+      CheckpointFile: filepath.Join(s.Config.StateDir, "game-log.checkpoint"),
+    })
+  }
+  if err != nil {
+    return err
+  }
+
+  // The spool is shared across runs, so logging output that the server didn't
+  // acknowledge before the reporter stopped is uploaded by the next run.
+  err = s.Spool.Init(filepath.Join(s.Config.StateDir, "spool"))
   if err != nil {
     return err
   }
-  // The server always needs the full network log, because its beginning
-  // contains region information.
-  s.NetLogWatcher.ReportExistingData()
+  s.Spool.SetLogger(s.logger())
 
-  s.Uploader.Init(s.Config.ServerUrl, s.Config.ServerToken, logLines)
+  s.Uploader.Init(s.Config.ServerUrl, s.Config.ServerToken, logLines,
+      &s.Spool)
+  s.Uploader.SetLogger(s.logger())
+  s.Uploader.SetBatchPolicy(s.Config.Batching)
+  s.Uploader.SetConfigRefresh(s.Config.ConfigRefresh)
   if err := s.Uploader.FetchConfig(); err != nil {
     return err
   }
+  s.serverConfig = s.Uploader.ServerConfig
+  if err := s.updateFilters(s.Uploader.ServerConfig); err != nil {
+    return err
+  }
+  if s.logDirWatcher != nil {
+    s.logDirWatcher.SetCategories(s.Uploader.ServerConfig.Categories)
+  }
+  if s.Config.StatusAddr != "" {
+    if err := s.StatusServer.Init(s.Config.StatusAddr, s); err != nil {
+      return err
+    }
+  }
 
   return nil
 }
 
+// logger returns the logger for the reporter's diagnostics.
+func (s *State) logger() *slog.Logger {
+  if s.Config.Logger == nil {
+    return discardLogger()
+  }
+  return s.Config.Logger
+}
+
+// UsesLogsDir returns true if the game log is split by category.
+func (s *State) UsesLogsDir() bool {
+  return s.Config.LogsDir != ""
+}
+
 // Writes Hearthstone's log configuration and touches its log files.
 func (s *State) ConfigLogging() error {
-  if err := WriteConfigFile(s.Config.ConfigFile,
-      s.Uploader.ServerConfig.Categories); err != nil {
+  if err := s.writeConfigFile(s.Uploader.ServerConfig.Categories);
+      err != nil {
     return err
   }
-  if err := TouchLogFile(s.Config.GameLogFile); err != nil {
-    return err
+  if !s.UsesLogsDir() {
+    if err := TouchLogFile(s.Config.GameLogFile); err != nil {
+      return err
+    }
   }
   if err := TouchLogFile(s.Config.NetLogFile); err != nil {
     return err
   }
   return nil
 }
+
+// UpdateServerConfig acts on a server config refreshed by the uploader.
+//
+// It returns true if Hearthstone must be restarted to pick up the new config.
+// Hearthstone only reads its logging config file when it starts, so the game
+// must be restarted whenever the logging categories change.
+func (s *State) UpdateServerConfig(serverConfig ServerConfig) (bool, error) {
+  if err := s.updateFilters(serverConfig); err != nil {
+    return false, err
+  }
+  s.serverConfig = serverConfig
+  if sameCategories(s.categories, serverConfig.Categories) {
+    return false, nil
+  }
+  if err := s.writeConfigFile(serverConfig.Categories); err != nil {
+    return false, err
+  }
+  if s.logDirWatcher != nil {
+    s.logDirWatcher.SetCategories(serverConfig.Categories)
+  }
+  return true, nil
+}
+
+// Shutdown stops the reporter, after uploading the logging output read so far.
+//
+// The watchers are stopped first, so their checkpoints cover all the lines
+// that they read. The lines are then processed and queued in the spool, and
+// the spool is uploaded until the server accepts all of it, or until ctx is
+// done. Logging output that isn't uploaded stays in the spool, and is
+// uploaded by the next run. The caller must keep draining the watchers' and
+// the uploader's error channels until Shutdown returns.
+//
+// It returns an *UndeliveredError if some logging output was not uploaded,
+// and the first other error encountered, if any.
+func (s *State) Shutdown(ctx context.Context) error {
+  err := s.Watchers.Stop()
+  s.Pipeline.Close()
+  uploadErr := s.Uploader.Stop(ctx)
+  if archiveErr := s.GameArchive.Close(); err == nil {
+    err = archiveErr
+  }
+  // NOTE: The status server is stopped after the uploader, so it reports
+  //       the upload's progress while the queued logging output is flushed.
+  if statusErr := s.StatusServer.Stop(); err == nil {
+    err = statusErr
+  }
+  if spoolErr := s.Spool.Close(); err == nil {
+    err = spoolErr
+  }
+  if err == nil {
+    err = uploadErr
+  }
+  return err
+}
+
+// RestoreConfigFile puts back the logging config from before the reporter
+// changed it.
+//
+// It returns false if the reporter didn't change the logging config.
+func (s *State) RestoreConfigFile() (bool, error) {
+  return RestoreConfigFile(s.Config.ConfigFile,
+      ConfigBackupFile(s.Config.StateDir))
+}
+
+// gameLogFilter builds the filter for the game log's lines.
+//
+// The filter combines the filters in the reporter's configuration with the
+// filters requested by the server.
+func (s *State) gameLogFilter() (LineFilter, error) {
+  var configFilter LineFilter
+  if s.Config.IncludeLines != "" || s.Config.ExcludeLines != "" {
+    filter, err := NewRegexpFilter(s.Config.IncludeLines,
+        s.Config.ExcludeLines)
+    if err != nil {
+      return nil, err
+    }
+    configFilter = filter
+  }
+  var categoryFilter LineFilter
+  if !s.Config.AllCategories {
+    s.categoryFilter = NewCategoryFilter(nil)
+    categoryFilter = s.categoryFilter
+  }
+  return AllFilters(categoryFilter, configFilter, &s.serverFilter), nil
+}
+
+// updateFilters applies the filtering in the server's config.
+func (s *State) updateFilters(serverConfig ServerConfig) error {
+  filter, err := serverConfig.Filters.Filter()
+  if err != nil {
+    return err
+  }
+  s.serverFilter.Set(filter)
+  if s.categoryFilter != nil {
+    s.categoryFilter.SetCategories(serverConfig.Categories)
+  }
+  return nil
+}
+
+// writeConfigFile backs up Hearthstone's logging config, then updates it.
+func (s *State) writeConfigFile(categories []string) error {
+  _, err := BackupConfigFile(s.Config.ConfigFile,
+      ConfigBackupFile(s.Config.StateDir))
+  if err != nil {
+    return err
+  }
+  // NOTE: Hearthstone only writes per-category log files when asked to.
+  err = WriteConfigFile(s.Config.ConfigFile, categories, s.UsesLogsDir())
+  if err != nil {
+    return err
+  }
+  s.categories = categories
+  return nil
+}
+
+// sameCategories returns true if two lists have the same logging categories.
+//
+// The categories' order does not matter.
+func sameCategories(categories1 []string, categories2 []string) bool {
+  if len(categories1) != len(categories2) {
+    return false
+  }
+  sorted1 := append([]string(nil), categories1...)
+  sorted2 := append([]string(nil), categories2...)
+  sort.Strings(sorted1)
+  sort.Strings(sorted2)
+  for i := range sorted1 {
+    if sorted1[i] != sorted2[i] {
+      return false
+    }
+  }
+  return true
+}
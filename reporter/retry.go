@@ -0,0 +1,136 @@
+package reporter
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "math/rand"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// The delay before the first retry of a failed upload.
+const minRetryDelay = 1 * time.Second
+
+// The maximum delay between retries of a failed upload.
+const maxRetryDelay = 5 * time.Minute
+
+// The maximum number of bytes read from the body of an error response.
+const maxErrorBodySize = 4096
+
+// UploadError is reported when the HTTP endpoint rejects an upload.
+type UploadError struct {
+  // The HTTP status code in the server's response.
+  StatusCode int
+  // The error message in the server's response, if any.
+  Message string
+  // True if the uploader stopped, because retrying cannot succeed.
+  //
+  // This happens when the server does not accept the reporter's token.
+  Fatal bool
+  // True if the rejected logging output was dropped from the spool, because
+  // the server would reject it again if it was retried.
+  //
+  // This happens when the server deems the request invalid, such as when it
+  // responds with 400 Bad Request.
+  Dropped bool
+}
+
+func (e *UploadError) Error() string {
+  message := e.Message
+  if message == "" {
+    message = http.StatusText(e.StatusCode)
+  }
+  if e.Fatal {
+    return fmt.Sprintf("Server refused upload (HTTP %d): %s", e.StatusCode,
+        message)
+  }
+  if e.Dropped {
+    return fmt.Sprintf("Server rejected upload, dropped its logging output " +
+        "(HTTP %d): %s", e.StatusCode, message)
+  }
+  return fmt.Sprintf("Server failed upload (HTTP %d): %s", e.StatusCode,
+      message)
+}
+
+// newUploadError builds the error reported for an unsuccessful response.
+//
+// It consumes the response's body, but does not close it.
+func newUploadError(response *http.Response) *UploadError {
+  uploadError := &UploadError{
+    StatusCode: response.StatusCode,
+    Fatal: response.StatusCode == http.StatusUnauthorized ||
+        response.StatusCode == http.StatusForbidden,
+  }
+
+  // The server can explain the error using the same JSON format that it
+  // uses to report errors for GET requests.
+  body, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+  var serverConfig ServerConfig
+  if json.Unmarshal(body, &serverConfig) == nil {
+    uploadError.Message = serverConfig.Error
+  }
+  return uploadError
+}
+
+// isPermanentFailure returns true if retrying a request that got a response
+// with the given status code cannot succeed.
+//
+// Client errors are permanent, except for 408 Request Timeout and 429 Too
+// Many Requests, which ask the client to try again.
+func isPermanentFailure(statusCode int) bool {
+  return statusCode >= 400 && statusCode < 500 &&
+      statusCode != http.StatusRequestTimeout &&
+      statusCode != http.StatusTooManyRequests
+}
+
+// retryDelay computes how long to wait before retrying a failed upload.
+//
+// The attempt argument is the number of failed attempts so far. The response
+// is nil if the upload failed without getting a response from the server.
+func retryDelay(attempt int, response *http.Response) time.Duration {
+  if response != nil {
+    if delay, ok := parseRetryAfter(response.Header.Get("Retry-After"));
+        ok {
+      return delay
+    }
+  }
+
+  // Exponential backoff, with jitter so that reporters that failed together
+  // don't retry together.
+  delay := maxRetryDelay
+  if attempt < 16 {
+    delay = minRetryDelay << uint(attempt)
+    if delay > maxRetryDelay {
+      delay = maxRetryDelay
+    }
+  }
+  return delay / 2 + time.Duration(rand.Int63n(int64(delay / 2) + 1))
+}
+
+// parseRetryAfter decodes the value of a Retry-After HTTP header.
+//
+// It returns false if the header is missing or invalid.
+func parseRetryAfter(value string) (time.Duration, bool) {
+  value = strings.TrimSpace(value)
+  if value == "" {
+    return 0, false
+  }
+  if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+    if seconds < 0 {
+      return 0, false
+    }
+    return time.Duration(seconds) * time.Second, true
+  }
+  if date, err := http.ParseTime(value); err == nil {
+    delay := time.Until(date)
+    if delay < 0 {
+      delay = 0
+    }
+    return delay, true
+  }
+  return 0, false
+}
@@ -0,0 +1,107 @@
+package reporter
+
+import (
+  "net/http"
+  "testing"
+  "time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+  future := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+  past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+  tests := []struct {
+    value string
+    // The parsed delay must be in [minDelay, maxDelay].
+    minDelay time.Duration
+    maxDelay time.Duration
+    ok bool
+  }{
+    {"120", 120 * time.Second, 120 * time.Second, true},
+    {" 0 ", 0, 0, true},
+    // An HTTP-date is rounded down to seconds, and time passes while the
+    // test runs.
+    {future, 85 * time.Second, 90 * time.Second, true},
+    {past, 0, 0, true},
+    {"", 0, 0, false},
+    {"-5", 0, 0, false},
+    {"1.5", 0, 0, false},
+    {"soon", 0, 0, false},
+  }
+  for _, test := range tests {
+    delay, ok := parseRetryAfter(test.value)
+    if ok != test.ok || delay < test.minDelay || delay > test.maxDelay {
+      t.Errorf("parseRetryAfter(%q) got %v %v, want %v-%v %v", test.value,
+          delay, ok, test.minDelay, test.maxDelay, test.ok)
+    }
+  }
+}
+
+func TestRetryDelay(t *testing.T) {
+  tests := []struct {
+    attempt int
+    retryAfter string
+    // The backoff delay, which is jittered down to half its value.
+    want time.Duration
+  }{
+    {0, "", minRetryDelay},
+    {1, "", 2 * minRetryDelay},
+    {4, "", 16 * minRetryDelay},
+    {8, "", 256 * minRetryDelay},
+    {9, "", maxRetryDelay},
+    {15, "", maxRetryDelay},
+    {1000, "", maxRetryDelay},
+    // Retry-After takes precedence over the backoff, and is not jittered.
+    {0, "30", 30 * time.Second},
+    {1000, "7", 7 * time.Second},
+    {3, "invalid", 8 * minRetryDelay},
+  }
+  for _, test := range tests {
+    response := &http.Response{Header: make(http.Header)}
+    if test.retryAfter != "" {
+      response.Header.Set("Retry-After", test.retryAfter)
+    }
+    minDelay := test.want / 2
+    if test.retryAfter != "" && test.retryAfter != "invalid" {
+      minDelay = test.want
+    }
+    for i := 0; i < 20; i += 1 {
+      delay := retryDelay(test.attempt, response)
+      if delay < minDelay || delay > test.want {
+        t.Errorf("retryDelay(%d, %q) got %v, want %v-%v", test.attempt,
+            test.retryAfter, delay, minDelay, test.want)
+        break
+      }
+    }
+  }
+
+  // Requests that got no response use the backoff.
+  if delay := retryDelay(20, nil); delay < maxRetryDelay / 2 ||
+      delay > maxRetryDelay {
+    t.Errorf("retryDelay(20, nil) got %v, want %v-%v", delay,
+        maxRetryDelay / 2, maxRetryDelay)
+  }
+}
+
+func TestIsPermanentFailure(t *testing.T) {
+  tests := []struct {
+    statusCode int
+    want bool
+  }{
+    {http.StatusBadRequest, true},
+    {http.StatusUnauthorized, true},
+    {http.StatusNotFound, true},
+    {http.StatusRequestEntityTooLarge, true},
+    {http.StatusUnprocessableEntity, true},
+    {http.StatusRequestTimeout, false},
+    {http.StatusTooManyRequests, false},
+    {http.StatusInternalServerError, false},
+    {http.StatusServiceUnavailable, false},
+    {http.StatusMovedPermanently, false},
+  }
+  for _, test := range tests {
+    if got := isPermanentFailure(test.statusCode); got != test.want {
+      t.Errorf("isPermanentFailure(%d) got %v, want %v", test.statusCode,
+          got, test.want)
+    }
+  }
+}
@@ -0,0 +1,211 @@
+package reporter
+
+import (
+  "context"
+  "time"
+)
+
+// The shutdown timeout used by hsreporter's command line.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Hooks receives the events of a reporter started by Run.
+//
+// The hooks are called one at a time, on the goroutine that called Run, so
+// they must return quickly. nil hooks are skipped.
+type Hooks struct {
+  // Receives the errors encountered while uploading. Run stops after an
+  // *UploadError whose Fatal field is set.
+  UploadError func(err error)
+  // Receives the errors encountered while watching the logs. The errors are
+  // *WatchError values.
+  WatchError func(err error)
+  // Receives the errors encountered while archiving games.
+  ArchiveError func(err error)
+  // Receives the errors encountered while acting on a refreshed server
+  // config.
+  ConfigError func(err error)
+  // Receives the errors encountered while serving the reporter's status.
+  StatusError func(err error)
+  // Receives the changes in the reporter's status.
+  Status func(status RunStatus)
+}
+
+// RunStatusKind identifies the changes reported to Hooks.Status.
+type RunStatusKind int
+
+const (
+  // The reporter started watching the logs.
+  RunStarted RunStatusKind = iota + 1
+  // The reporter acted on a refreshed server config.
+  RunConfigChanged
+  // The reporter stopped watching the logs, and is uploading the queued
+  // logging output.
+  RunStopping
+)
+
+// RunStatus describes a change in a running reporter's status.
+type RunStatus struct {
+  Kind RunStatusKind
+  // The server config in effect.
+  ServerConfig ServerConfig
+  // For RunStarted, the sources that resumed reading their logs where the
+  // previous run stopped.
+  Resumed []string
+  // For RunConfigChanged, true if Hearthstone must be restarted to pick up
+  // the new logging config.
+  RestartNeeded bool
+}
+
+// Run reports Hearthstone's logs until ctx is cancelled.
+//
+// It sets up a reporter with the given configuration, then calls State.Run.
+// Callers that add their own log sources should call State.Init, add the
+// sources, then call State.Run.
+func Run(ctx context.Context, config Config) error {
+  state := &State{Config: config}
+  if err := state.Init(); err != nil {
+    state.closeUnstarted()
+    return err
+  }
+  return state.Run(ctx)
+}
+
+// Start starts uploading, then starts watching the logs and serving the
+// reporter's status.
+//
+// The uploader must start before the watchers, so it drains their output.
+// Otherwise, a watcher can deadlock in Start while it reports the data that
+// already exists in its log. If Start fails, the uploader is stopped, and the
+// resources acquired by Init are released. It returns any error encountered.
+func (s *State) Start() error {
+  existingData := s.serverConfig.ExistingData
+  if err := s.Uploader.Start(); err != nil {
+    s.closeUnstarted()
+    return err
+  }
+  s.Pipeline.Start()
+  if err := s.Watchers.Start(existingData); err != nil {
+    s.Pipeline.Close()
+    stopped, cancel := context.WithCancel(context.Background())
+    cancel()
+    s.Uploader.Stop(stopped)
+    s.closeUnstarted()
+    return err
+  }
+  if s.Config.StatusAddr != "" {
+    s.StatusServer.Start()
+  }
+  return nil
+}
+
+// closeUnstarted releases the resources acquired by Init when the reporter
+// can't start.
+//
+// Errors are ignored, because the caller reports the error that stopped the
+// reporter from starting.
+func (s *State) closeUnstarted() {
+  s.StatusServer.Stop()
+  s.GameArchive.Close()
+  s.Spool.Close()
+}
+
+// Run reports Hearthstone's logs until ctx is cancelled.
+//
+// The caller must have called Init. Run writes Hearthstone's logging config,
+// starts the reporter, and acts on the server config's changes. When ctx is
+// cancelled, Run calls Shutdown, giving it Config.ShutdownTimeout to upload
+// the queued logging output. Errors and status changes are reported to
+// Config.Hooks.
+//
+// It returns the error that stopped the reporter, such as an *UploadError
+// caused by an invalid token. Otherwise, it returns the result of Shutdown,
+// which is nil if all the logging output was uploaded.
+func (s *State) Run(ctx context.Context) error {
+  if err := s.ConfigLogging(); err != nil {
+    s.closeUnstarted()
+    return err
+  }
+  if err := s.Start(); err != nil {
+    return err
+  }
+  hooks := s.Config.Hooks
+  s.reportStatus(RunStatus{Kind: RunStarted, Resumed: s.Watchers.Resumed()})
+
+  uploadErrors := s.Uploader.Errors()
+  configUpdates := s.Uploader.ConfigUpdates()
+  var fatalErr error
+  runLoop: for {
+    select {
+    case <- ctx.Done():
+      break runLoop
+    case serverConfig := <- configUpdates:
+      restartNeeded, err := s.UpdateServerConfig(serverConfig)
+      if err != nil {
+        callErrorHook(hooks.ConfigError, err)
+        continue
+      }
+      s.reportStatus(RunStatus{
+        Kind: RunConfigChanged,
+        RestartNeeded: restartNeeded,
+      })
+    case err := <- uploadErrors:
+      callErrorHook(hooks.UploadError, err)
+      if uploadErr, ok := err.(*UploadError); ok && uploadErr.Fatal {
+        fatalErr = err
+        break runLoop
+      }
+    case err := <- s.Watchers.Errors():
+      callErrorHook(hooks.WatchError, err)
+    case err := <- s.GameArchive.Errors():
+      callErrorHook(hooks.ArchiveError, err)
+    case err := <- s.StatusServer.Errors():
+      callErrorHook(hooks.StatusError, err)
+    }
+  }
+
+  s.reportStatus(RunStatus{Kind: RunStopping})
+  // NOTE: Shutdown runs on its own goroutine, so this goroutine keeps
+  //       draining the error channels while the queued data is uploaded.
+  shutdownDone := make(chan error, 1)
+  go func() {
+    shutdownCtx, cancel := context.WithTimeout(context.Background(),
+        s.Config.ShutdownTimeout)
+    defer cancel()
+    shutdownDone <- s.Shutdown(shutdownCtx)
+  }()
+  for {
+    select {
+    case err := <- shutdownDone:
+      if fatalErr != nil {
+        return fatalErr
+      }
+      return err
+    case err := <- uploadErrors:
+      callErrorHook(hooks.UploadError, err)
+    case err := <- s.Watchers.Errors():
+      callErrorHook(hooks.WatchError, err)
+    case err := <- s.GameArchive.Errors():
+      callErrorHook(hooks.ArchiveError, err)
+    case err := <- s.StatusServer.Errors():
+      callErrorHook(hooks.StatusError, err)
+    }
+  }
+}
+
+// reportStatus calls the status hook, if it is set.
+//
+// The status is completed with the server config in effect.
+func (s *State) reportStatus(status RunStatus) {
+  if s.Config.Hooks.Status == nil {
+    return
+  }
+  status.ServerConfig = s.serverConfig
+  s.Config.Hooks.Status(status)
+}
+
+// callErrorHook calls an error hook, if it is set.
+func callErrorHook(hook func(err error), err error) {
+  if hook != nil {
+    hook(err)
+  }
+}
@@ -0,0 +1,485 @@
+package reporter
+
+import (
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "hash/crc32"
+  "io"
+  "io/ioutil"
+  "log/slog"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+// The size after which the spool starts writing to a new segment file.
+const defaultSpoolSegmentSize = 4 * 1024 * 1024
+
+// The size of the header that precedes each entry in a segment file.
+//
+// The header contains the entry's length and its CRC32, both as big-endian
+// 32-bit integers.
+const spoolFrameHeaderSize = 8
+
+// Returned by readSpoolFrame when an entry's header or checksum is invalid.
+var errSpoolCorrupted = errors.New("Corrupted spool entry")
+
+// Spool is a persistent queue of log entries, stored in a directory.
+//
+// Entries are appended to segment files, and are removed from the queue when
+// they are acknowledged. The read position is saved to disk on every
+// acknowledgement, so a new process that opens the same directory resumes
+// reading at the first unacknowledged entry. Corrupted entries, and the rest
+// of the segment file that holds them, are skipped and logged.
+type Spool struct {
+  // The directory holding the segment files and the read cursor.
+  dir string
+  // The size after which a new segment file is started.
+  segmentSize int64
+  // Receives the spool's diagnostics.
+  logger *slog.Logger
+
+  // Protects all the fields below.
+  mutex sync.Mutex
+  // The segment files on disk, sorted by ID.
+  segments []spoolSegment
+  // The segment file currently receiving appended entries.
+  writeFile *os.File
+  // The position of the first unacknowledged entry.
+  cursor SpoolPosition
+  // Receives a value when an entry is appended.
+  ready chan struct{}
+  // The number of entries appended since the spool was opened.
+  appended int64
+}
+
+// SpoolPosition identifies an entry in the spool.
+type SpoolPosition struct {
+  // The ID of the segment file containing the entry.
+  Segment int64
+  // The entry's offset in its segment file.
+  Offset int64
+}
+
+// SpoolBatch is a sequence of consecutive entries read from a spool.
+type SpoolBatch struct {
+  // The entries' contents.
+  Entries [][]byte
+  // The total size of the entries' contents.
+  Size int
+  // True if the batch stopped at a size limit, before the spool's end.
+  Full bool
+  // The position right after the last entry in the batch.
+  end SpoolPosition
+}
+
+// spoolSegment describes a segment file in the spool.
+type spoolSegment struct {
+  id int64
+  size int64
+}
+
+// Init opens the spool in the given directory, creating it if necessary.
+//
+// It returns any error encountered.
+func (s *Spool) Init(dir string) error {
+  s.dir = dir
+  s.segmentSize = defaultSpoolSegmentSize
+  s.ready = make(chan struct{}, 1)
+  s.segments = nil
+  s.writeFile = nil
+  s.appended = 0
+  s.logger = discardLogger()
+
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return err
+  }
+  if err := s.loadSegments(); err != nil {
+    return err
+  }
+  if err := s.loadCursor(); err != nil {
+    return err
+  }
+  if err := s.removeReadSegments(); err != nil {
+    return err
+  }
+  if s.pendingBytes() > 0 {
+    s.ready <- struct{}{}
+  }
+  return nil
+}
+
+// SetLogger makes the spool log its diagnostics.
+//
+// It must be called before the spool is used.
+func (s *Spool) SetLogger(logger *slog.Logger) {
+  s.logger = logger
+}
+
+// Ready returns a channel that receives a value when new entries are queued.
+//
+// The channel has a buffer of one value, so a reader that checks the spool
+// after it gets a value from the channel will not miss any entries.
+func (s *Spool) Ready() <-chan struct{} {
+  return s.ready
+}
+
+// Append adds an entry to the end of the spool.
+//
+// It returns any error encountered.
+func (s *Spool) Append(entry []byte) error {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  last := len(s.segments) - 1
+  if s.writeFile == nil || s.segments[last].size >= s.segmentSize {
+    if err := s.startSegment(); err != nil {
+      return err
+    }
+    last = len(s.segments) - 1
+  }
+
+  // NOTE: The header and the data are written with a single call, so a torn
+  //       write can only happen if the whole process dies.
+  frame := make([]byte, spoolFrameHeaderSize + len(entry))
+  binary.BigEndian.PutUint32(frame[0:4], uint32(len(entry)))
+  binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(entry))
+  copy(frame[spoolFrameHeaderSize:], entry)
+  bytesWritten, err := s.writeFile.Write(frame)
+  s.segments[last].size += int64(bytesWritten)
+  if err != nil {
+    return err
+  }
+  s.appended += 1
+
+  select {
+  case s.ready <- struct{}{}:
+  default:
+  }
+  return nil
+}
+
+// Peek reads the entries at the front of the spool without removing them.
+//
+// It returns any error encountered.
+// The returned batch stops before exceeding maxBytes or maxEntries, except
+// that it always contains at least one entry if the spool is not empty. A
+// non-positive limit is ignored.
+func (s *Spool) Peek(maxBytes int, maxEntries int) (SpoolBatch, error) {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  batch := SpoolBatch{end: s.cursor}
+  for _, segment := range s.segments {
+    if segment.id < batch.end.Segment {
+      continue
+    }
+    if segment.id > batch.end.Segment {
+      batch.end = SpoolPosition{Segment: segment.id, Offset: 0}
+    }
+    if batch.end.Offset >= segment.size {
+      continue
+    }
+
+    file, err := os.Open(s.segmentPath(segment.id))
+    if err != nil {
+      return SpoolBatch{}, err
+    }
+    for batch.end.Offset < segment.size {
+      entry, frameSize, err := readSpoolFrame(file, batch.end.Offset,
+          segment.size)
+      if err == errSpoolCorrupted {
+        if len(batch.Entries) > 0 {
+          // The corrupted data is skipped by the next Peek, after the
+          // entries before it are acknowledged.
+          file.Close()
+          batch.Full = true
+          return batch, nil
+        }
+        if err := s.skipSegment(segment, batch.end.Offset); err != nil {
+          file.Close()
+          return SpoolBatch{}, err
+        }
+        batch.end = s.cursor
+        break
+      }
+      if err != nil {
+        file.Close()
+        return SpoolBatch{}, err
+      }
+      if len(batch.Entries) > 0 {
+        if (maxBytes > 0 && batch.Size + len(entry) > maxBytes) ||
+            (maxEntries > 0 && len(batch.Entries) >= maxEntries) {
+          file.Close()
+          batch.Full = true
+          return batch, nil
+        }
+      }
+      batch.Entries = append(batch.Entries, entry)
+      batch.Size += len(entry)
+      batch.end.Offset += frameSize
+    }
+    file.Close()
+  }
+  return batch, nil
+}
+
+// Ack removes the entries in a batch returned by Peek from the spool.
+//
+// It returns any error encountered.
+// The new read position is persisted before Ack returns.
+func (s *Spool) Ack(batch SpoolBatch) error {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  s.cursor = batch.end
+  if err := s.saveCursor(); err != nil {
+    return err
+  }
+  return s.removeReadSegments()
+}
+
+// skipSegment moves the read position past a corrupted segment file's end.
+//
+// Without this, every Peek would stop at the corrupted entry, and the
+// entries queued after it would never be uploaded. The caller must hold the
+// mutex.
+func (s *Spool) skipSegment(segment spoolSegment, offset int64) error {
+  s.logger.Warn("Skipped corrupted spool data",
+      "file", s.segmentPath(segment.id), "offset", offset,
+      "lostBytes", segment.size - offset)
+  s.cursor = SpoolPosition{Segment: segment.id, Offset: segment.size}
+  if err := s.saveCursor(); err != nil {
+    return err
+  }
+  return s.removeReadSegments()
+}
+
+// PendingBytes returns the size of the unacknowledged data in the spool.
+//
+// The size includes the per-entry overhead of the segment files.
+func (s *Spool) PendingBytes() int64 {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  return s.pendingBytes()
+}
+
+// AppendedEntries returns the number of entries appended since Init.
+//
+// The difference between two results is the number of entries appended in
+// between the calls.
+func (s *Spool) AppendedEntries() int64 {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  return s.appended
+}
+
+// pendingBytes implements PendingBytes. The caller must hold the mutex.
+func (s *Spool) pendingBytes() int64 {
+  pending := int64(0)
+  for _, segment := range s.segments {
+    if segment.id > s.cursor.Segment {
+      pending += segment.size
+    } else if segment.id == s.cursor.Segment {
+      pending += segment.size - s.cursor.Offset
+    }
+  }
+  return pending
+}
+
+// Close closes the segment file that receives appended entries.
+//
+// It returns any error encountered.
+func (s *Spool) Close() error {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  if s.writeFile == nil {
+    return nil
+  }
+  err := s.writeFile.Sync()
+  if closeErr := s.writeFile.Close(); err == nil {
+    err = closeErr
+  }
+  s.writeFile = nil
+  return err
+}
+
+// startSegment closes the current segment file and creates a new one.
+func (s *Spool) startSegment() error {
+  if s.writeFile != nil {
+    if err := s.writeFile.Sync(); err != nil {
+      return err
+    }
+    if err := s.writeFile.Close(); err != nil {
+      return err
+    }
+    s.writeFile = nil
+  }
+
+  id := int64(0)
+  if len(s.segments) > 0 {
+    id = s.segments[len(s.segments) - 1].id + 1
+  }
+  file, err := os.OpenFile(s.segmentPath(id),
+      os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0644)
+  if err != nil {
+    return err
+  }
+  s.writeFile = file
+  s.segments = append(s.segments, spoolSegment{id: id, size: 0})
+  return nil
+}
+
+// loadSegments discovers the segment files left by a previous process.
+//
+// The last segment file is truncated to its last complete entry, because the
+// previous process may have died while appending to it.
+func (s *Spool) loadSegments() error {
+  fileInfos, err := ioutil.ReadDir(s.dir)
+  if err != nil {
+    return err
+  }
+  for _, fileInfo := range fileInfos {
+    name := fileInfo.Name()
+    if !strings.HasSuffix(name, ".seg") {
+      continue
+    }
+    id, err := strconv.ParseInt(strings.TrimSuffix(name, ".seg"), 10, 64)
+    if err != nil {
+      continue
+    }
+    s.segments = append(s.segments,
+        spoolSegment{id: id, size: fileInfo.Size()})
+  }
+  sort.Slice(s.segments, func(i, j int) bool {
+    return s.segments[i].id < s.segments[j].id
+  })
+  if len(s.segments) == 0 {
+    return nil
+  }
+
+  last := &s.segments[len(s.segments) - 1]
+  file, err := os.OpenFile(s.segmentPath(last.id), os.O_RDWR, 0644)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+  validSize := int64(0)
+  for validSize < last.size {
+    _, frameSize, err := readSpoolFrame(file, validSize, last.size)
+    if err != nil {
+      break
+    }
+    validSize += frameSize
+  }
+  if validSize < last.size {
+    if err := file.Truncate(validSize); err != nil {
+      return err
+    }
+    last.size = validSize
+  }
+  return nil
+}
+
+// loadCursor reads the read position saved by a previous process.
+func (s *Spool) loadCursor() error {
+  s.cursor = SpoolPosition{}
+  if len(s.segments) > 0 {
+    s.cursor.Segment = s.segments[0].id
+  }
+
+  data, err := ioutil.ReadFile(s.cursorPath())
+  if os.IsNotExist(err) {
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  var saved SpoolPosition
+  if _, err := fmt.Sscanf(string(data), "%d %d", &saved.Segment,
+      &saved.Offset); err != nil {
+    return fmt.Errorf("Invalid spool cursor in %s: %v", s.cursorPath(), err)
+  }
+  if saved.Segment >= s.cursor.Segment {
+    s.cursor = saved
+  }
+  return nil
+}
+
+// saveCursor persists the read position.
+func (s *Spool) saveCursor() error {
+  data := fmt.Sprintf("%d %d\n", s.cursor.Segment, s.cursor.Offset)
+  return writeFileAtomically(s.cursorPath(), []byte(data), 0644)
+}
+
+// removeReadSegments deletes the segment files that were completely read.
+//
+// The segment file that receives appended entries is never removed.
+func (s *Spool) removeReadSegments() error {
+  for len(s.segments) > 1 {
+    segment := s.segments[0]
+    if segment.id > s.cursor.Segment ||
+        (segment.id == s.cursor.Segment && s.cursor.Offset < segment.size) {
+      break
+    }
+    err := os.Remove(s.segmentPath(segment.id))
+    if err != nil && !os.IsNotExist(err) {
+      return err
+    }
+    s.segments = s.segments[1:]
+    if s.cursor.Segment <= segment.id {
+      s.cursor = SpoolPosition{Segment: s.segments[0].id, Offset: 0}
+    }
+  }
+  return nil
+}
+
+// segmentPath returns the path to a segment file.
+func (s *Spool) segmentPath(id int64) string {
+  return filepath.Join(s.dir, fmt.Sprintf("%016d.seg", id))
+}
+
+// cursorPath returns the path to the file holding the read position.
+func (s *Spool) cursorPath() string {
+  return filepath.Join(s.dir, "cursor")
+}
+
+// readSpoolFrame reads the entry at the given offset in a segment file.
+//
+// The frame must end before fileSize, so a corrupted header can't cause a
+// huge allocation. It returns the entry's contents, and the size of the
+// entry's frame in the segment file. It returns errSpoolCorrupted if the
+// frame is invalid.
+func readSpoolFrame(file *os.File, offset int64, fileSize int64) ([]byte,
+    int64, error) {
+  if fileSize - offset < spoolFrameHeaderSize {
+    return nil, 0, errSpoolCorrupted
+  }
+  header := make([]byte, spoolFrameHeaderSize)
+  if _, err := file.ReadAt(header, offset); err != nil {
+    return nil, 0, err
+  }
+  size := binary.BigEndian.Uint32(header[0:4])
+  checksum := binary.BigEndian.Uint32(header[4:8])
+  if int64(size) > fileSize - offset - spoolFrameHeaderSize {
+    return nil, 0, errSpoolCorrupted
+  }
+
+  entry := make([]byte, size)
+  _, err := file.ReadAt(entry, offset + spoolFrameHeaderSize)
+  if err == io.EOF {
+    err = io.ErrUnexpectedEOF
+  }
+  if err != nil {
+    return nil, 0, err
+  }
+  if crc32.ChecksumIEEE(entry) != checksum {
+    return nil, 0, errSpoolCorrupted
+  }
+  return entry, spoolFrameHeaderSize + int64(size), nil
+}
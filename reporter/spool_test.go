@@ -0,0 +1,321 @@
+package reporter
+
+import (
+  "encoding/binary"
+  "os"
+  "path/filepath"
+  "reflect"
+  "testing"
+  "time"
+)
+
+// openTestSpool opens a spool in a directory, failing the test on errors.
+func openTestSpool(t *testing.T, dir string) *Spool {
+  spool := &Spool{}
+  if err := spool.Init(dir); err != nil {
+    t.Fatalf("Init(%s): %v", dir, err)
+  }
+  return spool
+}
+
+// appendTestEntries adds entries to a spool, failing the test on errors.
+func appendTestEntries(t *testing.T, spool *Spool, entries ...string) {
+  for _, entry := range entries {
+    if err := spool.Append([]byte(entry)); err != nil {
+      t.Fatalf("Append(%q): %v", entry, err)
+    }
+  }
+}
+
+// peekTestEntries reads a batch from a spool, failing the test on errors.
+func peekTestEntries(t *testing.T, spool *Spool, maxBytes int,
+    maxEntries int) (SpoolBatch, []string) {
+  batch, err := spool.Peek(maxBytes, maxEntries)
+  if err != nil {
+    t.Fatalf("Peek(%d, %d): %v", maxBytes, maxEntries, err)
+  }
+  var entries []string
+  for _, entry := range batch.Entries {
+    entries = append(entries, string(entry))
+  }
+  return batch, entries
+}
+
+func TestSpoolPeekLimits(t *testing.T) {
+  tests := []struct {
+    maxBytes int
+    maxEntries int
+    want []string
+    wantFull bool
+  }{
+    {0, 0, []string{"aaaa", "bb", "cccccc", "d"}, false},
+    {6, 0, []string{"aaaa", "bb"}, true},
+    {7, 0, []string{"aaaa", "bb"}, true},
+    {13, 0, []string{"aaaa", "bb", "cccccc", "d"}, false},
+    {0, 3, []string{"aaaa", "bb", "cccccc"}, true},
+    {0, 4, []string{"aaaa", "bb", "cccccc", "d"}, false},
+    // The first entry is returned even if it is over the limits.
+    {2, 0, []string{"aaaa"}, true},
+    {100, 1, []string{"aaaa"}, true},
+  }
+  spool := openTestSpool(t, t.TempDir())
+  defer spool.Close()
+  appendTestEntries(t, spool, "aaaa", "bb", "cccccc", "d")
+  for _, test := range tests {
+    batch, entries := peekTestEntries(t, spool, test.maxBytes,
+        test.maxEntries)
+    if !reflect.DeepEqual(entries, test.want) || batch.Full != test.wantFull {
+      t.Errorf("Peek(%d, %d) got %q full=%v, want %q full=%v",
+          test.maxBytes, test.maxEntries, entries, batch.Full, test.want,
+          test.wantFull)
+    }
+  }
+}
+
+func TestSpoolAckPersistsAcrossReopen(t *testing.T) {
+  dir := t.TempDir()
+  spool := openTestSpool(t, dir)
+  appendTestEntries(t, spool, "one", "two", "three")
+  batch, _ := peekTestEntries(t, spool, 0, 2)
+  if err := spool.Ack(batch); err != nil {
+    t.Fatalf("Ack: %v", err)
+  }
+  if err := spool.Close(); err != nil {
+    t.Fatalf("Close: %v", err)
+  }
+
+  spool = openTestSpool(t, dir)
+  defer spool.Close()
+  select {
+  case <- spool.Ready():
+  default:
+    t.Errorf("Ready did not signal the entries left by the previous run")
+  }
+  if pending := spool.PendingBytes(); pending !=
+      int64(spoolFrameHeaderSize + len("three")) {
+    t.Errorf("PendingBytes got %d after reopening", pending)
+  }
+  appendTestEntries(t, spool, "four")
+  _, entries := peekTestEntries(t, spool, 0, 0)
+  if want := []string{"three", "four"}; !reflect.DeepEqual(entries, want) {
+    t.Errorf("Peek after reopening got %q, want %q", entries, want)
+  }
+}
+
+func TestSpoolSegmentRollover(t *testing.T) {
+  dir := t.TempDir()
+  spool := openTestSpool(t, dir)
+  defer spool.Close()
+  // Each segment holds two 12-byte frames before it reaches the limit.
+  spool.segmentSize = 20
+  appendTestEntries(t, spool, "e001", "e002", "e003", "e004", "e005")
+  segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+  if len(segments) != 3 {
+    t.Fatalf("got %d segment files, want 3", len(segments))
+  }
+
+  batch, entries := peekTestEntries(t, spool, 0, 3)
+  if want := []string{"e001", "e002", "e003"}; !reflect.DeepEqual(entries,
+      want) {
+    t.Errorf("Peek across segments got %q, want %q", entries, want)
+  }
+  if err := spool.Ack(batch); err != nil {
+    t.Fatalf("Ack: %v", err)
+  }
+  segments, _ = filepath.Glob(filepath.Join(dir, "*.seg"))
+  if len(segments) != 2 {
+    t.Errorf("got %d segment files after Ack, want 2", len(segments))
+  }
+
+  batch, entries = peekTestEntries(t, spool, 0, 0)
+  if want := []string{"e004", "e005"}; !reflect.DeepEqual(entries, want) {
+    t.Errorf("Peek after Ack got %q, want %q", entries, want)
+  }
+  if err := spool.Ack(batch); err != nil {
+    t.Fatalf("Ack: %v", err)
+  }
+  // The segment that receives appended entries is never removed.
+  segments, _ = filepath.Glob(filepath.Join(dir, "*.seg"))
+  if len(segments) != 1 || spool.PendingBytes() != 0 {
+    t.Errorf("got %d segment files and %d pending bytes after acking all",
+        len(segments), spool.PendingBytes())
+  }
+}
+
+func TestSpoolSkipsCorruptedFrames(t *testing.T) {
+  tests := []struct {
+    name string
+    // Changes the first segment file, which holds e001, e002 and e003 at
+    // offsets 0, 12 and 24.
+    corrupt func(file *os.File) error
+    want []string
+  }{
+    {
+      name: "checksum mismatch",
+      corrupt: func(file *os.File) error {
+        _, err := file.WriteAt([]byte("X"), 12 + spoolFrameHeaderSize)
+        return err
+      },
+      want: []string{"e001", "e004", "e005"},
+    },
+    {
+      name: "oversized frame",
+      corrupt: func(file *os.File) error {
+        size := make([]byte, 4)
+        binary.BigEndian.PutUint32(size, 0xfffffff0)
+        _, err := file.WriteAt(size, 12)
+        return err
+      },
+      want: []string{"e001", "e004", "e005"},
+    },
+    {
+      name: "frame past the segment's end",
+      corrupt: func(file *os.File) error {
+        size := make([]byte, 4)
+        binary.BigEndian.PutUint32(size, 5)
+        _, err := file.WriteAt(size, 24)
+        return err
+      },
+      want: []string{"e001", "e002", "e004", "e005"},
+    },
+    {
+      name: "torn header",
+      corrupt: func(file *os.File) error {
+        return file.Truncate(24 + 3)
+      },
+      want: []string{"e001", "e002", "e004", "e005"},
+    },
+  }
+  for _, test := range tests {
+    dir := t.TempDir()
+    spool := openTestSpool(t, dir)
+    spool.segmentSize = 30
+    appendTestEntries(t, spool, "e001", "e002", "e003", "e004", "e005")
+    spool.Close()
+    file, err := os.OpenFile(spool.segmentPath(0), os.O_RDWR, 0)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if err := test.corrupt(file); err != nil {
+      t.Fatal(err)
+    }
+    file.Close()
+
+    spool = openTestSpool(t, dir)
+    var entries []string
+    for i := 0; i < 4; i += 1 {
+      batch, batchEntries := peekTestEntries(t, spool, 0, 0)
+      if len(batchEntries) == 0 {
+        break
+      }
+      entries = append(entries, batchEntries...)
+      if err := spool.Ack(batch); err != nil {
+        t.Fatalf("%s: Ack: %v", test.name, err)
+      }
+    }
+    if !reflect.DeepEqual(entries, test.want) {
+      t.Errorf("%s: got %q, want %q", test.name, entries, test.want)
+    }
+    spool.Close()
+  }
+}
+
+func TestSpoolTruncatesTornLastFrame(t *testing.T) {
+  dir := t.TempDir()
+  spool := openTestSpool(t, dir)
+  appendTestEntries(t, spool, "whole", "torn")
+  spool.Close()
+  path := spool.segmentPath(0)
+  fileInfo, err := os.Stat(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := os.Truncate(path, fileInfo.Size() - 2); err != nil {
+    t.Fatal(err)
+  }
+
+  spool = openTestSpool(t, dir)
+  defer spool.Close()
+  appendTestEntries(t, spool, "after")
+  _, entries := peekTestEntries(t, spool, 0, 0)
+  if want := []string{"whole", "after"}; !reflect.DeepEqual(entries, want) {
+    t.Errorf("got %q, want %q", entries, want)
+  }
+}
+
+func TestSpoolEntryRoundTrip(t *testing.T) {
+  tests := []LogLine{
+    {Source: "game", Offset: 0, Data: []byte("[Power] x\n")},
+    {
+      Source: "net",
+      Offset: 123456789012,
+      Time: time.Unix(1792340103, 200000000),
+      Data: []byte("Network line\r\n"),
+    },
+    {
+      Source: "Power",
+      Offset: 42,
+      Time: time.Unix(1792340103, 0),
+      Data: []byte("[Power] CREATE_GAME\n"),
+      GameId: "vDvMkVCn0vvTrXb8plFRQg",
+      GameBoundary: GameStarted,
+    },
+    {Source: "game", Data: []byte{}, GameBoundary: GameTruncated},
+  }
+  for _, line := range tests {
+    decoded, err := decodeSpoolEntry(encodeSpoolEntry(line))
+    if err != nil {
+      t.Errorf("decodeSpoolEntry(%+v): %v", line, err)
+      continue
+    }
+    if decoded.Source != line.Source || decoded.Offset != line.Offset ||
+        !decoded.Time.Equal(line.Time) ||
+        string(decoded.Data) != string(line.Data) ||
+        decoded.GameId != line.GameId ||
+        decoded.GameBoundary != line.GameBoundary {
+      t.Errorf("round trip of %+v got %+v", line, decoded)
+    }
+  }
+}
+
+func TestDecodeSpoolEntry(t *testing.T) {
+  tests := []struct {
+    name string
+    entry []byte
+    want LogLine
+    wantErr bool
+  }{
+    {
+      name: "raw line from an older reporter",
+      entry: []byte("[Power] x\n"),
+      want: LogLine{Offset: -1, Data: []byte("[Power] x\n")},
+    },
+    {
+      name: "truncated source",
+      entry: []byte{logLineSpoolTag, 10, 'g'},
+      wantErr: true,
+    },
+    {
+      name: "missing offset",
+      entry: []byte{logLineSpoolTag, 1, 'g'},
+      wantErr: true,
+    },
+    {
+      name: "truncated game ID",
+      entry: []byte{gameLineSpoolTag, 1, 'g', 0, 0, 5, 'a'},
+      wantErr: true,
+    },
+  }
+  for _, test := range tests {
+    line, err := decodeSpoolEntry(test.entry)
+    if test.wantErr {
+      if err == nil {
+        t.Errorf("%s: got %+v, want an error", test.name, line)
+      }
+      continue
+    }
+    if err != nil || !reflect.DeepEqual(line, test.want) {
+      t.Errorf("%s: got %+v %v, want %+v", test.name, line, err, test.want)
+    }
+  }
+}
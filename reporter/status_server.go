@@ -0,0 +1,156 @@
+package reporter
+
+import (
+  "encoding/json"
+  "net"
+  "net/http"
+  "time"
+)
+
+// Status is a snapshot of a running reporter's health.
+type Status struct {
+  // The HTTP endpoint that receives the logging output.
+  ServerUrl string `json:"serverUrl"`
+  // The logging categories requested by the server.
+  Categories []string `json:"categories"`
+  // The progress of the log watchers.
+  Watchers []WatchStats `json:"watchers"`
+  // The size of the logging output waiting to be uploaded.
+  QueuedBytes int64 `json:"queuedBytes"`
+  // The number of POST requests accepted by the server.
+  UploadedBatches int64 `json:"uploadedBatches"`
+  // The time when the server last accepted a POST request. nil if no
+  // request was accepted yet.
+  LastPost *time.Time `json:"lastPost"`
+  // The last error reported by the uploader. nil if there was no error.
+  LastError *StatusError `json:"lastError"`
+}
+
+// StatusError describes an error in a Status.
+type StatusError struct {
+  // The error's message.
+  Message string `json:"message"`
+  // The time when the error was reported.
+  Time time.Time `json:"time"`
+}
+
+// Status returns a snapshot of the reporter's health.
+//
+// It can be called while the reporter runs.
+func (s *State) Status() Status {
+  uploadStats := s.Uploader.Stats()
+  status := Status{
+    ServerUrl: s.Config.ServerUrl,
+    Categories: s.Uploader.CurrentConfig().Categories,
+    Watchers: s.Watchers.Stats(),
+    QueuedBytes: s.Spool.PendingBytes(),
+    UploadedBatches: uploadStats.Batches,
+  }
+  if !uploadStats.LastPost.IsZero() {
+    status.LastPost = &uploadStats.LastPost
+  }
+  if uploadStats.LastError != nil {
+    status.LastError = &StatusError{
+      Message: uploadStats.LastError.Error(),
+      Time: uploadStats.LastErrorTime,
+    }
+  }
+  return status
+}
+
+// StatusServer serves a reporter's status and metrics over HTTP.
+//
+// GET /status returns the reporter's Status as JSON, and GET /metrics returns
+// the reporter's counters in Prometheus' text format.
+type StatusServer struct {
+  // The reporter whose status is served.
+  state *State
+  // Accepts the HTTP connections.
+  listener net.Listener
+  // Serves the HTTP requests.
+  server *http.Server
+  // Sink for errors encountered while serving.
+  errors chan error
+  // True if Start was called.
+  started bool
+}
+
+// Init starts listening for HTTP connections on the given address.
+//
+// The address is passed to net.Listen, such as "127.0.0.1:8789". The status
+// includes file paths, so it should only be served to the local machine. It
+// returns any error encountered.
+func (s *StatusServer) Init(addr string, state *State) error {
+  s.state = state
+  s.errors = make(chan error, 5)
+
+  var err error
+  if s.listener, err = net.Listen("tcp", addr); err != nil {
+    return err
+  }
+  mux := http.NewServeMux()
+  mux.HandleFunc("/status", s.serveStatus)
+  mux.HandleFunc("/metrics", s.serveMetrics)
+  s.server = &http.Server{Handler: mux}
+  return nil
+}
+
+// Addr returns the address that the server listens on.
+func (s *StatusServer) Addr() string {
+  return s.listener.Addr().String()
+}
+
+// Errors returns the channel for errors encountered while serving.
+func (s *StatusServer) Errors() <-chan error {
+  return s.errors
+}
+
+// Start spawns a goroutine that serves HTTP requests.
+func (s *StatusServer) Start() {
+  s.started = true
+  go func() {
+    if err := s.server.Serve(s.listener); err != http.ErrServerClosed {
+      s.errors <- err
+    }
+  }()
+}
+
+// Stop stops serving, and closes the connections in progress.
+//
+// It does nothing if Init was not called. It returns any error encountered.
+func (s *StatusServer) Stop() error {
+  if s.server == nil {
+    return nil
+  }
+  if !s.started {
+    return s.listener.Close()
+  }
+  return s.server.Close()
+}
+
+// serveStatus handles GET /status.
+func (s *StatusServer) serveStatus(writer http.ResponseWriter,
+    request *http.Request) {
+  if request.Method != "GET" {
+    http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  jsonBytes, err := json.MarshalIndent(s.state.Status(), "", "  ")
+  if err != nil {
+    http.Error(writer, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  writer.Header().Set("Content-Type", "application/json")
+  writer.Write(append(jsonBytes, '\n'))
+}
+
+// serveMetrics handles GET /metrics.
+func (s *StatusServer) serveMetrics(writer http.ResponseWriter,
+    request *http.Request) {
+  if request.Method != "GET" {
+    http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+  s.state.WriteMetrics(writer)
+}
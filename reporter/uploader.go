@@ -2,26 +2,94 @@ package reporter
 
 import (
   "bytes"
+  "context"
   "crypto/rand"
   "encoding/base64"
   "encoding/json"
   "fmt"
+  "io"
   "io/ioutil"
+  "log/slog"
   "net/http"
+  "reflect"
   "strconv"
   "strings"
+  "sync"
+  "time"
 )
 
+// The time to wait before retrying to read from a spool that failed.
+const spoolRetryDelay = 5 * time.Second
+
+// The POST response header that asks the reporter to refresh its config.
+const configRefreshHeader = "X-HsReport-Config-Refresh"
+
+// The POST request header that marks uploads of old logging output.
+const backfillHeader = "X-HsReport-Backfill"
+
 // The JSON response returned by a GET request to the HTTP endpoint.
 type ServerConfig struct {
   Categories []string
   Error string
   ExistingData bool
+  // Content-Encoding values accepted for POST bodies, in order of preference.
+  Encodings []string
+  // Overrides for the reporter's batching policy.
+  Batching ServerBatchPolicy
+  // Restricts the game log lines that are uploaded.
+  Filters ServerLineFilters
+  // The upload protocol version that the server wants, if it supports more
+  // than protocol 1.
+  Proto int
+}
+
+// UploadStats summarizes the logging output accepted by the HTTP endpoint.
+type UploadStats struct {
+  // The number of POST requests accepted by the server.
+  Batches int64
+  // The size of the logging output in the accepted requests.
+  RawBytes int64
+  // The size of the accepted requests' bodies, after compression.
+  SentBytes int64
+  // The number of log lines in the accepted requests.
+  Lines int64
+  // The number of accepted log lines from each source.
+  SourceLines map[string]int64
+  // The number of POST requests that failed and were retried.
+  Retries int64
+  // The number of POST requests that the server refused for good. The
+  // logging output of requests with an invalid token stays in the spool.
+  // Other rejected requests, such as requests that the server deemed
+  // invalid, are dropped.
+  RejectedBatches int64
+  // The number of log lines that could not be queued in the spool, or could
+  // not be decoded after they were queued.
+  DroppedLines int64
+  // The time when the server last accepted a POST request.
+  LastPost time.Time
+  // The last error reported by the uploader, and when it was reported.
+  LastError error
+  LastErrorTime time.Time
+  // The time taken by the POST requests that got a response.
+  PostLatency LatencyHistogram
+}
+
+// CompressionRatio returns the ratio between raw and sent bytes.
+//
+// It returns 1 if nothing was uploaded yet.
+func (s UploadStats) CompressionRatio() float64 {
+  if s.SentBytes == 0 {
+    return 1
+  }
+  return float64(s.RawBytes) / float64(s.SentBytes)
 }
 
 // The logic for uploading logging output to a HTTP endpoint.
 type Uploader struct {
   // The logging configuration requested by the HTTP endpoint.
+  //
+  // After Start is called, the uploader may refresh the configuration, so
+  // the field must not be read anymore. ConfigUpdates reports the changes.
   ServerConfig ServerConfig
 
   // The HTTP endpoint's URL.
@@ -32,21 +100,184 @@ type Uploader struct {
   idNonce string
   // The sequence number in the X-HsReport-ID HTTP header value.
   idSequence int64
+  // The protocol version used for uploads.
+  protocol int
   // Source for Hearthstone's combined game and network logging output.
-  logLines <-chan []byte
+  logLines <-chan LogLine
+  // Persistent queue holding the logging output that wasn't uploaded yet.
+  spool *Spool
   // Sink for HTTP errors.
   errors chan error
   // http.Client instance used for all communication with the HTTP endpoint.
   httpClient http.Client
+  // The batching policy requested by the reporter's user.
+  clientBatchPolicy BatchPolicy
+  // The batching policy in effect, after applying the server's overrides.
+  batchPolicy BatchPolicy
+  // Compresses request bodies using the encoding accepted by the server.
+  encoder batchEncoder
+  // Protects stats and currentConfig, and the writes to batchPolicy,
+  // protocol and encoder, which are read by the uploading goroutine without
+  // the lock.
+  statsMutex sync.Mutex
+  // Summary of the uploaded logging output.
+  stats UploadStats
+  // The server config in effect. Unlike ServerConfig, it can be read while
+  // the uploader runs.
+  currentConfig ServerConfig
+  // The time between periodic refreshes of the server's config.
+  refreshInterval time.Duration
+  // The time of the next periodic refresh of the server's config.
+  nextRefresh time.Time
+  // True if the server asked the uploader to refresh its config.
+  refreshRequested bool
+  // Sink for the server configs that changed while uploading.
+  configUpdates chan ServerConfig
+  // True if the uploaded logging output was read from old log files.
+  backfill bool
+  // Cancelled to abort the uploader's HTTP requests and retries.
+  requestContext context.Context
+  // Cancels requestContext.
+  cancelRequests context.CancelFunc
+  // Closed to make the uploader send the spooled logging output right away.
+  flushing chan struct{}
+  // Closed when the spooling goroutine exits.
+  spoolDone chan struct{}
+  // Closed when the uploading goroutine exits.
+  uploadDone chan struct{}
+  // True if Start was called.
+  started bool
+  // Receives the uploader's diagnostics.
+  logger *slog.Logger
+}
+
+// UndeliveredError is returned by Stop when logging output was not uploaded.
+//
+// The logging output stays in the spool, and is uploaded by the next
+// uploader that uses the spool.
+type UndeliveredError struct {
+  // The size of the spooled logging output that was not uploaded.
+  PendingBytes int64
+}
+
+func (e *UndeliveredError) Error() string {
+  return fmt.Sprintf("%d bytes of logging output were not uploaded",
+      e.PendingBytes)
 }
 
 // Init sets up the uploader's initial state.
+//
+// Logging output read from logLines is queued in the spool before it is
+// uploaded, so it survives server outages and reporter restarts.
 func (u *Uploader) Init(serverUrl string, serverToken string,
-    logLines <-chan []byte) {
+    logLines <-chan LogLine, spool *Spool) {
   u.logLines = logLines
+  u.spool = spool
   u.url = serverUrl
   u.authHeader = "Token " + serverToken
   u.errors = make(chan error, 5)
+  u.configUpdates = make(chan ServerConfig, 1)
+  u.requestContext, u.cancelRequests = context.WithCancel(
+      context.Background())
+  u.flushing = make(chan struct{})
+  u.spoolDone = make(chan struct{})
+  u.uploadDone = make(chan struct{})
+  u.started = false
+  u.logger = discardLogger()
+  u.SetBatchPolicy(DefaultBatchPolicy())
+}
+
+// SetLogger makes the uploader log its diagnostics.
+//
+// Each POST request is logged with its sequence number and batch size.
+func (u *Uploader) SetLogger(logger *slog.Logger) {
+  u.logger = logger
+}
+
+// SetConfigRefresh makes the uploader periodically re-fetch the server config.
+//
+// A zero interval disables periodic refreshes. The server can still ask for a
+// refresh in its response to a POST request.
+func (u *Uploader) SetConfigRefresh(interval time.Duration) {
+  u.refreshInterval = interval
+}
+
+// ConfigUpdates returns a channel that receives the server's new config.
+//
+// The uploader only sends a config on the channel if it differs from the
+// previous config. If the channel's receiver falls behind, it only gets the
+// most recent config.
+func (u *Uploader) ConfigUpdates() <-chan ServerConfig {
+  return u.configUpdates
+}
+
+// SetBackfill marks the uploaded logging output as read from old log files.
+//
+// The server can use the mark to de-duplicate logging output that it may
+// have received before.
+func (u *Uploader) SetBackfill(backfill bool) {
+  u.backfill = backfill
+}
+
+// SetBatchPolicy changes how logging output is grouped into POST requests.
+//
+// The server can override parts of the policy in its config. The caller must
+// call FetchConfig after calling this.
+func (u *Uploader) SetBatchPolicy(policy BatchPolicy) {
+  u.statsMutex.Lock()
+  defer u.statsMutex.Unlock()
+  u.clientBatchPolicy = policy
+  u.batchPolicy = policy
+}
+
+// BatchPolicy returns the batching policy in effect.
+//
+// It can be called while the uploader runs.
+func (u *Uploader) BatchPolicy() BatchPolicy {
+  u.statsMutex.Lock()
+  defer u.statsMutex.Unlock()
+  return u.batchPolicy
+}
+
+// Protocol returns the protocol version used for uploads.
+//
+// It can be called while the uploader runs.
+func (u *Uploader) Protocol() int {
+  u.statsMutex.Lock()
+  defer u.statsMutex.Unlock()
+  return u.protocol
+}
+
+// Encoding returns the Content-Encoding used for uploaded logging output.
+//
+// It can be called while the uploader runs.
+func (u *Uploader) Encoding() string {
+  u.statsMutex.Lock()
+  defer u.statsMutex.Unlock()
+  return u.encoder.encoding
+}
+
+// Stats returns a summary of the logging output uploaded so far.
+//
+// It can be called while the uploader runs.
+func (u *Uploader) Stats() UploadStats {
+  u.statsMutex.Lock()
+  defer u.statsMutex.Unlock()
+  stats := u.stats
+  stats.SourceLines = make(map[string]int64, len(u.stats.SourceLines))
+  for source, lines := range u.stats.SourceLines {
+    stats.SourceLines[source] = lines
+  }
+  return stats
+}
+
+// CurrentConfig returns the server config in effect.
+//
+// Unlike the ServerConfig field, it can be called while the uploader runs.
+func (u *Uploader) CurrentConfig() ServerConfig {
+  u.statsMutex.Lock()
+  defer u.statsMutex.Unlock()
+  return u.currentConfig
 }
 
 // Errors returns a channel that receives upload errors.
@@ -56,34 +287,49 @@ func(u *Uploader) Errors() <-chan error {
 
 // FetchConfig obtains logging configuration data from the server.
 //
-// It returns any error encountered.
+// It returns any error encountered. If the server responds with an error
+// status, the error is an *UploadError, which is fatal if the server refused
+// the reporter's token.
 func (u *Uploader) FetchConfig() error {
   // NOTE: It'd be more natural to generate the upload session nonce in Init().
-  //       However, that'd require having Init() return an error. Generating a
-  //       new session nonce whenever we get the logging configuration seems
-  //       reasonable enough.
-  sessionBytes := make([]byte, 16)
-  if _, err := rand.Read(sessionBytes); err != nil {
-    return err
+  //       However, that'd require having Init() return an error. Generating
+  //       the session nonce when we first get the logging configuration seems
+  //       reasonable enough. Refreshes keep the nonce, because the upload
+  //       session continues.
+  if u.idNonce == "" {
+    sessionBytes := make([]byte, 16)
+    if _, err := rand.Read(sessionBytes); err != nil {
+      return err
+    }
+    u.idNonce = strings.TrimRight(
+        base64.URLEncoding.EncodeToString(sessionBytes), "=")
+    u.idSequence = 0
   }
-  u.idNonce = strings.TrimRight(
-      base64.URLEncoding.EncodeToString(sessionBytes), "=")
-  u.idSequence = 0
 
   request, err := http.NewRequest("GET", u.url, nil)
   if err != nil {
     return err
   }
+  request = request.WithContext(u.requestContext)
   request.Header.Add("Authorization", u.authHeader)
   request.Header.Add("X-HsReport-Id", u.idNonce + " " +
                      strconv.FormatInt(u.idSequence, 10))
+  // NOTE: Servers that predate protocol negotiation reject any version other
+  //       than 1, so the highest supported version is in a separate header.
   request.Header.Add("X-HsReport-Proto", "1")
+  request.Header.Add("X-HsReport-Proto-Max",
+                     strconv.Itoa(maxProtocolVersion))
 
   response, err := u.httpClient.Do(request)
   if err != nil {
     return fmt.Errorf("Error communicating to server: %v", err)
   }
   u.idSequence += 1
+  if response.StatusCode < 200 || response.StatusCode >= 300 {
+    uploadErr := newUploadError(response)
+    response.Body.Close()
+    return uploadErr
+  }
 
   jsonBytes, err := ioutil.ReadAll(response.Body)
   response.Body.Close()
@@ -91,62 +337,436 @@ func (u *Uploader) FetchConfig() error {
     return fmt.Errorf("Error reading server response: %v", err)
   }
 
-  if err = json.Unmarshal(jsonBytes, &u.ServerConfig); err != nil {
+  // NOTE: The JSON is decoded into a fresh value, so that settings that the
+  //       server dropped from its config don't linger after a refresh.
+  var serverConfig ServerConfig
+  if err = json.Unmarshal(jsonBytes, &serverConfig); err != nil {
     return fmt.Errorf("Error decoding server JSON: %v", err)
   }
-  if u.ServerConfig.Error != "" {
-    return fmt.Errorf("Server error: %s", u.ServerConfig.Error)
+  if serverConfig.Error != "" {
+    return fmt.Errorf("Server error: %s", serverConfig.Error)
   }
+  u.statsMutex.Lock()
+  // NOTE: Servers that predate compression support don't list encodings, so
+  //       they get uncompressed request bodies.
+  err = u.encoder.Init(chooseEncoding(serverConfig.Encodings))
+  if err != nil {
+    u.statsMutex.Unlock()
+    return err
+  }
+  u.ServerConfig = serverConfig
+  u.batchPolicy = u.clientBatchPolicy.Override(u.ServerConfig.Batching)
+  u.protocol = negotiateProtocol(u.ServerConfig.Proto)
+  u.currentConfig = serverConfig
+  u.statsMutex.Unlock()
+  u.logger.Debug("Fetched server config", "sequence", u.idSequence - 1,
+      "categories", u.ServerConfig.Categories, "protocol", u.protocol,
+      "encoding", u.encoder.encoding)
 
   return nil
 }
 
+// refreshDue returns true if the uploader should re-fetch the server config.
+func (u *Uploader) refreshDue() bool {
+  if u.refreshRequested {
+    return true
+  }
+  return u.refreshInterval > 0 && !time.Now().Before(u.nextRefresh)
+}
+
+// refreshTimer returns a channel that receives a value when a periodic
+// refresh of the server config is due.
+//
+// The channel is nil if periodic refreshes are disabled, so it never
+// receives a value.
+func (u *Uploader) refreshTimer() <-chan time.Time {
+  if u.refreshInterval <= 0 {
+    return nil
+  }
+  return time.After(time.Until(u.nextRefresh))
+}
+
+// refreshConfig re-fetches the server config, and reports any changes.
+func (u *Uploader) refreshConfig() {
+  u.refreshRequested = false
+  u.nextRefresh = time.Now().Add(u.refreshInterval)
+
+  oldConfig := u.ServerConfig
+  if err := u.FetchConfig(); err != nil {
+    u.reportError(err)
+    return
+  }
+  if reflect.DeepEqual(oldConfig, u.ServerConfig) {
+    return
+  }
+  u.logger.Info("Server config changed",
+      "categories", u.ServerConfig.Categories)
+  // NOTE: This goroutine is the channel's only sender, so a stale config
+  //       can be swapped for the new config without blocking.
+  select {
+  case <- u.configUpdates:
+  default:
+  }
+  u.configUpdates <- u.ServerConfig
+}
+
 // Start starts uploading Hearthstone logging information to the HTTP endpoint.
 func (u *Uploader) Start() error {
+  u.nextRefresh = time.Now().Add(u.refreshInterval)
+  u.started = true
+  go u.spoolLoop()
   go u.uploadLoop()
   return nil
 }
 
-// uploadLoop reads Hearthstone's logging output and posts it to the server.
+// Stop uploads the logging output that is still queued, then stops.
+//
+// The caller must close the channel that the uploader reads lines from, so
+// the uploader knows when all the lines are queued. Stop waits until the
+// server accepts all the queued logging output, without waiting for batches
+// to fill up, or until ctx is done. In the latter case, the request in
+// progress is aborted. The uploader cannot be restarted after it stops.
+//
+// It returns an *UndeliveredError if some logging output was not uploaded.
+// The caller must keep draining the errors channel until Stop returns.
+func (u *Uploader) Stop(ctx context.Context) error {
+  if u.started {
+    select {
+    case <- u.spoolDone:
+    case <- ctx.Done():
+    }
+    close(u.flushing)
+    select {
+    case <- u.uploadDone:
+    case <- ctx.Done():
+    }
+    u.cancelRequests()
+    <- u.uploadDone
+    <- u.spoolDone
+    u.started = false
+  }
+  u.cancelRequests()
+
+  pending := u.spool.PendingBytes()
+  u.logger.Info("Uploader stopped", "pendingBytes", pending)
+  if pending > 0 {
+    return &UndeliveredError{PendingBytes: pending}
+  }
+  return nil
+}
+
+// isFlushing returns true if Stop asked the uploader to flush its queue.
+func (u *Uploader) isFlushing() bool {
+  select {
+  case <- u.flushing:
+    return true
+  default:
+    return false
+  }
+}
+
+// reportError sends an error to the errors channel.
+//
+// Errors are dropped after the uploader's requests are aborted, so a caller
+// that stopped draining the channel can't block the uploader.
+func (u *Uploader) reportError(err error) {
+  u.statsMutex.Lock()
+  u.stats.LastError = err
+  u.stats.LastErrorTime = time.Now()
+  u.statsMutex.Unlock()
+  select {
+  case u.errors <- err:
+  case <- u.requestContext.Done():
+  }
+}
+
+// sleep waits for the given time, or until the uploader's requests are
+// aborted.
+//
+// It returns false if the requests were aborted.
+func (u *Uploader) sleep(delay time.Duration) bool {
+  timer := time.NewTimer(delay)
+  defer timer.Stop()
+  select {
+  case <- timer.C:
+    return true
+  case <- u.requestContext.Done():
+    return false
+  }
+}
+
+// spoolLoop reads Hearthstone's logging output and queues it for uploading.
+func (u *Uploader) spoolLoop() {
+  defer close(u.spoolDone)
+  for line := range u.logLines {
+    if err := u.spool.Append(encodeSpoolEntry(line)); err != nil {
+      u.statsMutex.Lock()
+      u.stats.DroppedLines += 1
+      u.statsMutex.Unlock()
+      u.reportError(err)
+      continue
+    }
+    // NOTE: The line's watcher only advances its checkpoint past the lines
+    //       that were spooled, so a crash can't skip the lines that are
+    //       still in the channels.
+    line.markSpooled()
+  }
+}
+
+// uploadBatch is a chunk of logging output that is posted in one request.
+//
+// A batch's body is never modified after the batch is built, so retrying a
+// failed request sends the exact same bytes, with the same sequence number.
+type uploadBatch struct {
+  // The spool entries that make up the batch.
+  spoolBatch SpoolBatch
+  // The request body, compressed according to encoding.
+  body []byte
+  // The request body's Content-Encoding.
+  encoding string
+  // The protocol version used to encode the request body.
+  protocol int
+  // The size of the logging output in the batch, before compression.
+  rawSize int
+  // The number of log lines in the batch.
+  lines int
+  // The number of log lines in the batch from each source.
+  sourceLines map[string]int64
+  // The sequence number in the X-HsReport-Id HTTP header value.
+  sequence int64
+}
+
+// uploadLoop reads queued logging output and posts it to the server.
 func (u *Uploader) uploadLoop() {
-  buffer := bytes.Buffer{}
+  defer close(u.uploadDone)
+  var lastPost time.Time
   for {
-    firstLine := <- u.logLines
-    buffer.Write(firstLine)
-
-    // Batch all available lines in the same request.
-    batchingLoop: for {
-      select {
-      case line := <- u.logLines:
-        buffer.Write(line)
-      default:
-        break batchingLoop
+    // NOTE: The server config is not refreshed while flushing, because the
+    //       new config would not be acted on.
+    if u.refreshDue() && !u.isFlushing() {
+      u.refreshConfig()
+    }
+    spoolBatch, err := u.waitForBatch(lastPost)
+    if err == errConfigRefreshDue {
+      continue
+    }
+    if err == errSpoolDrained {
+      return
+    }
+    if err != nil {
+      u.reportError(err)
+      if !u.sleep(spoolRetryDelay) {
+        return
+      }
+      continue
+    }
+    lastPost = time.Now()
+    batch, err := u.newBatch(spoolBatch)
+    if err != nil {
+      u.reportError(err)
+      if !u.sleep(spoolRetryDelay) {
+        return
+      }
+      continue
+    }
+    if batch.lines == 0 {
+      // All the batch's entries were dropped.
+      if err := u.spool.Ack(spoolBatch); err != nil {
+        u.reportError(err)
+      }
+      continue
+    }
+
+    if err := u.postBatch(batch); err != nil {
+      // The batch stays in the spool, and will be uploaded by a reporter
+      // that has a valid token, or by the next reporter if Stop aborted the
+      // upload.
+      if u.requestContext.Err() == nil {
+        u.reportError(err)
       }
+      return
+    }
+    // NOTE: The batch is only removed from the spool after the server
+    //       accepts it, or rejects it for good, so a restart never loses
+    //       queued logging output.
+    if err := u.spool.Ack(spoolBatch); err != nil {
+      u.reportError(err)
     }
+  }
+}
 
-    request, err := http.NewRequest("POST", u.url, &buffer)
+// newBatch snapshots spooled logging output into a compressed batch.
+//
+// Spool entries that cannot be decoded are left out of the batch, and are
+// counted and reported as dropped lines. They are acknowledged along with the
+// rest of the batch, so they don't hold up the spool.
+func (u *Uploader) newBatch(spoolBatch SpoolBatch) (*uploadBatch, error) {
+  lines := make([]LogLine, 0, len(spoolBatch.Entries))
+  sourceLines := make(map[string]int64)
+  var decodeErr error
+  dropped := 0
+  for _, entry := range spoolBatch.Entries {
+    line, err := decodeSpoolEntry(entry)
     if err != nil {
-      u.errors <- err
+      decodeErr = err
+      dropped += 1
       continue
     }
-    request.Header.Add("Authorization", u.authHeader)
-    request.Header.Add("Content-Type", "application/octet-stream")
-    request.Header.Add("X-HsReport-Id", u.idNonce + " " +
-                       strconv.FormatInt(u.idSequence, 10))
+    lines = append(lines, line)
+    sourceLines[line.Source] += 1
+  }
+  if dropped > 0 {
+    u.statsMutex.Lock()
+    u.stats.DroppedLines += int64(dropped)
+    u.statsMutex.Unlock()
+    u.logger.Warn("Dropped undecodable spool entries", "entries", dropped,
+        "error", decodeErr)
+    u.reportError(fmt.Errorf("Dropped %d log lines that could not be " +
+        "decoded from the spool: %v", dropped, decodeErr))
+  }
+  if len(lines) == 0 {
+    return &uploadBatch{spoolBatch: spoolBatch}, nil
+  }
+  rawBody, err := encodeBatchBody(lines, u.protocol)
+  if err != nil {
+    return nil, err
+  }
+  body, err := u.encoder.Encode(rawBody)
+  if err != nil {
+    return nil, err
+  }
+  return &uploadBatch{
+    spoolBatch: spoolBatch,
+    body: body,
+    encoding: u.encoder.encoding,
+    protocol: u.protocol,
+    rawSize: len(rawBody),
+    lines: len(lines),
+    sourceLines: sourceLines,
+    sequence: u.idSequence,
+  }, nil
+}
 
-    postSucceeded := false
-    for attemptsLeft := 3; attemptsLeft > 0; attemptsLeft -= 1 {
-      response, err := u.httpClient.Do(request)
-      if err == nil {
+// postBatch uploads a batch, retrying until the server accepts it.
+//
+// It returns an error if the server refused the reporter's token, or if Stop
+// aborted the upload. If the server rejects the batch itself in a way that
+// cannot be fixed by retrying, the rejection is reported on the errors
+// channel, and postBatch returns nil so the batch is dropped. Other errors
+// are also reported on the errors channel.
+func (u *Uploader) postBatch(batch *uploadBatch) error {
+  logger := u.logger.With("sequence", batch.sequence,
+      "lines", batch.lines, "bytes", batch.rawSize)
+  for attempt := 0; ; attempt += 1 {
+    request, err := u.newBatchRequest(batch)
+    if err != nil {
+      return err
+    }
+    startTime := time.Now()
+    response, err := u.httpClient.Do(request)
+    if err != nil && u.requestContext.Err() != nil {
+      return u.requestContext.Err()
+    }
+    if err == nil {
+      duration := time.Since(startTime)
+      u.recordLatency(duration)
+      if response.StatusCode >= 200 && response.StatusCode < 300 {
+        if response.Header.Get(configRefreshHeader) != "" {
+          u.refreshRequested = true
+        }
         response.Body.Close()
-        u.idSequence += 1
-        postSucceeded = true
-        break
+        u.idSequence = batch.sequence + 1
+        u.recordBatch(batch)
+        logger.Debug("Uploaded batch", "sentBytes", len(batch.body),
+            "attempt", attempt, "duration", duration)
+        return nil
+      }
+      uploadErr := newUploadError(response)
+      response.Body.Close()
+      if uploadErr.Fatal || isPermanentFailure(response.StatusCode) {
+        u.statsMutex.Lock()
+        u.stats.RejectedBatches += 1
+        u.statsMutex.Unlock()
       }
-      u.errors <- err
+      if uploadErr.Fatal {
+        return uploadErr
+      }
+      if isPermanentFailure(response.StatusCode) {
+        // NOTE: Retrying would send the same request, which the server would
+        //       reject again, and would hold up the rest of the spool.
+        uploadErr.Dropped = true
+        u.idSequence = batch.sequence + 1
+        logger.Warn("Dropped rejected batch", "status", response.StatusCode)
+        u.reportError(uploadErr)
+        return nil
+      }
+      err = uploadErr
     }
-    if postSucceeded {
-      buffer.Reset()
+    // NOTE: The sequence number is not incremented, so the server can tell
+    //       that the retry carries the same data as the failed request. The
+    //       error itself is reported on the errors channel.
+    delay := retryDelay(attempt, response)
+    logger.Debug("Retrying batch", "attempt", attempt, "delay", delay)
+    u.statsMutex.Lock()
+    u.stats.Retries += 1
+    u.statsMutex.Unlock()
+    u.reportError(err)
+    if !u.sleep(delay) {
+      return u.requestContext.Err()
     }
   }
 }
+
+// newBatchRequest builds a POST request that uploads a batch.
+func (u *Uploader) newBatchRequest(batch *uploadBatch) (*http.Request,
+    error) {
+  request, err := http.NewRequest("POST", u.url, bytes.NewReader(batch.body))
+  if err != nil {
+    return nil, err
+  }
+  request = request.WithContext(u.requestContext)
+  // NOTE: The HTTP client uses GetBody to replay the body when it follows
+  //       redirects or retries requests on a new connection.
+  request.ContentLength = int64(len(batch.body))
+  request.GetBody = func() (io.ReadCloser, error) {
+    return ioutil.NopCloser(bytes.NewReader(batch.body)), nil
+  }
+  request.Header.Add("Authorization", u.authHeader)
+  request.Header.Add("Content-Type", protocolContentType(batch.protocol))
+  if batch.protocol >= 2 {
+    request.Header.Add("X-HsReport-Proto", strconv.Itoa(batch.protocol))
+  }
+  if batch.encoding != "identity" {
+    request.Header.Add("Content-Encoding", batch.encoding)
+  }
+  request.Header.Add("X-HsReport-Id", u.idNonce + " " +
+                     strconv.FormatInt(batch.sequence, 10))
+  if u.backfill {
+    request.Header.Add(backfillHeader, "1")
+  }
+  return request, nil
+}
+
+// recordBatch updates the upload statistics for an accepted batch.
+func (u *Uploader) recordBatch(batch *uploadBatch) {
+  u.statsMutex.Lock()
+  defer u.statsMutex.Unlock()
+  u.stats.Batches += 1
+  u.stats.RawBytes += int64(batch.rawSize)
+  u.stats.SentBytes += int64(len(batch.body))
+  u.stats.Lines += int64(batch.lines)
+  if u.stats.SourceLines == nil {
+    u.stats.SourceLines = make(map[string]int64)
+  }
+  for source, lines := range batch.sourceLines {
+    u.stats.SourceLines[source] += lines
+  }
+  u.stats.LastPost = time.Now()
+}
+
+// recordLatency adds a POST request's duration to the upload statistics.
+func (u *Uploader) recordLatency(duration time.Duration) {
+  u.statsMutex.Lock()
+  defer u.statsMutex.Unlock()
+  u.stats.PostLatency.Observe(duration)
+}
@@ -0,0 +1,125 @@
+package reporter
+
+import (
+  "context"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+)
+
+// testServer is an HTTP endpoint that records the uploaded logging output.
+type testServer struct {
+  // The JSON config returned to GET requests.
+  config string
+  // The status code returned to POST requests.
+  postStatus int
+
+  mutex sync.Mutex
+  // The bodies of the POST requests.
+  posts []string
+}
+
+func (s *testServer) ServeHTTP(writer http.ResponseWriter,
+    request *http.Request) {
+  if request.Method == "GET" {
+    writer.Write([]byte(s.config))
+    return
+  }
+  body, _ := ioutil.ReadAll(request.Body)
+  s.mutex.Lock()
+  s.posts = append(s.posts, string(body))
+  s.mutex.Unlock()
+  writer.WriteHeader(s.postStatus)
+}
+
+// newTestUploader sets up an uploader that posts a spool's entries to a test
+// server.
+func newTestUploader(t *testing.T, server *httptest.Server,
+    spool *Spool) *Uploader {
+  logLines := make(chan LogLine)
+  close(logLines)
+  uploader := &Uploader{}
+  uploader.Init(server.URL, "token", logLines, spool)
+  if err := uploader.FetchConfig(); err != nil {
+    t.Fatalf("FetchConfig: %v", err)
+  }
+  return uploader
+}
+
+func TestUploaderDropsUndecodableEntries(t *testing.T) {
+  handler := &testServer{config: "{}", postStatus: http.StatusOK}
+  server := httptest.NewServer(handler)
+  defer server.Close()
+
+  spool := openTestSpool(t, t.TempDir())
+  defer spool.Close()
+  appendTestEntries(t, spool,
+      string(encodeSpoolEntry(LogLine{Source: "game",
+          Data: []byte("[Power] a\n")})),
+      string([]byte{logLineSpoolTag, 10, 'g'}),
+      string(encodeSpoolEntry(LogLine{Source: "game",
+          Data: []byte("[Power] b\n")})))
+
+  uploader := newTestUploader(t, server, spool)
+  if err := uploader.Start(); err != nil {
+    t.Fatalf("Start: %v", err)
+  }
+  ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+  defer cancel()
+  if err := uploader.Stop(ctx); err != nil {
+    t.Fatalf("Stop: %v", err)
+  }
+
+  if posted := strings.Join(handler.posts, ""); posted !=
+      "[Power] a\n[Power] b\n" {
+    t.Errorf("server got %q", posted)
+  }
+  if stats := uploader.Stats(); stats.DroppedLines != 1 || stats.Lines != 2 {
+    t.Errorf("got %d dropped and %d uploaded lines, want 1 and 2",
+        stats.DroppedLines, stats.Lines)
+  }
+  if errors := len(uploader.Errors()); errors != 1 {
+    t.Errorf("got %d errors, want 1", errors)
+  }
+}
+
+func TestFetchConfigErrors(t *testing.T) {
+  tests := []struct {
+    status int
+    body string
+    wantFatal bool
+    wantMessage string
+  }{
+    {http.StatusUnauthorized, "", true, ""},
+    {http.StatusForbidden, `{"error":"Token revoked"}`, true, "Token revoked"},
+    {http.StatusNotFound, "", false, ""},
+    {http.StatusServiceUnavailable, "<html>Down</html>", false, ""},
+  }
+  for _, test := range tests {
+    server := httptest.NewServer(http.HandlerFunc(
+        func(writer http.ResponseWriter, request *http.Request) {
+          writer.WriteHeader(test.status)
+          writer.Write([]byte(test.body))
+        }))
+    uploader := &Uploader{}
+    uploader.Init(server.URL, "token", nil, nil)
+    err := uploader.FetchConfig()
+    server.Close()
+
+    uploadErr, ok := err.(*UploadError)
+    if !ok {
+      t.Errorf("HTTP %d: got error %v, want an *UploadError", test.status,
+          err)
+      continue
+    }
+    if uploadErr.StatusCode != test.status ||
+        uploadErr.Fatal != test.wantFatal ||
+        uploadErr.Message != test.wantMessage {
+      t.Errorf("HTTP %d: got %+v", test.status, uploadErr)
+    }
+  }
+}
@@ -2,17 +2,36 @@ package reporter
 
 import (
   "bytes"
+  "log/slog"
   "os"
+  "sync"
+  "time"
   fsnotify "gopkg.in/fsnotify.v1"
 )
 
+// WatchStats summarizes the lines read by a log watcher.
+type WatchStats struct {
+  // The name that identifies the log in uploaded data, such as "game".
+  Source string `json:"source"`
+  // Path to the log file.
+  File string `json:"file"`
+  // The position in the log file after the last reported line.
+  Offset int64 `json:"offset"`
+  // The number of lines read from the log file.
+  LinesRead int64 `json:"linesRead"`
+  // The number of lines that were read, and rejected by the filter.
+  LinesFiltered int64 `json:"linesFiltered"`
+}
+
 type LogWatcher struct {
+  // The name that identifies the log in uploaded data, such as "game".
+  source string
   // Path to the log file that will be watched.
   logFile string
   // Filesystem notifications client.
   fsWatcher *fsnotify.Watcher
   // Sink for the lines written to the log file.
-  logLines chan<- []byte
+  logLines chan<- LogLine
   // Tells the log watch loop when to stop.
   commands chan int
   // Sink for errors encountered by the log file watching loop.
@@ -23,15 +42,60 @@ type LogWatcher struct {
   readOffset int64
   // The buffer used to read from the file.
   lineBuffer []byte
-  // True if lines that don't start with [ should be discarded.
-  filterLines bool
+  // Decides which lines are reported. nil reports all lines.
+  filter LineFilter
+  // Path to the state file holding the watcher's checkpoint.
+  checkpointFile string
+  // The spooled offset saved in the checkpoint file.
+  checkpointOffset int64
+  // True if the watcher resumed reading from its checkpoint.
+  resumed bool
+  // Prepended to every reported line.
+  linePrefix []byte
+  // True if the next reported line does not follow the previous one.
+  gap bool
+  // The number of lines reported before the log was last truncated.
+  baseLines int64
+  // The offset where the watcher started reading the current log data.
+  baseOffset int64
+  // True while the goroutine spawned by Start is running.
+  listening bool
+  // Receives the watcher's diagnostics.
+  logger *slog.Logger
+  // Protects stats.
+  statsMutex sync.Mutex
+  // Summary of the lines read so far.
+  stats WatchStats
+
+  // Protects the fields below, which track the reported lines that the
+  // uploader has spooled.
+  spoolMutex sync.Mutex
+  // The number of lines reported by the watcher.
+  sentLines int64
+  // The number of reported lines that were spooled. Lines are spooled in the
+  // order in which they are reported.
+  spooledLines int64
+  // The offset right after the last spooled line.
+  spooledOffset int64
+  // Closed when all the reported lines are spooled, if Stop is waiting.
+  spoolDrained chan struct{}
 }
 
+// How long Stop waits for the reported lines to be spooled.
+const spoolDrainTimeout = 5 * time.Second
+
+// How often a watcher saves the checkpoint covering the spooled lines.
+const checkpointInterval = 1 * time.Second
+
 // Init sets up the filesystem watcher.
-func (l *LogWatcher) Init(logFile string, filterLines bool,
-    logLines chan<- []byte) error {
+//
+// The source name is attached to every line reported by the watcher. If the
+// filter is not nil, only the lines that it accepts are reported.
+func (l *LogWatcher) Init(source string, logFile string, filter LineFilter,
+    logLines chan<- LogLine) error {
+  l.source = source
   l.logFile = logFile
-  l.filterLines = filterLines
+  l.filter = filter
   l.logLines = logLines
 
   var err error
@@ -42,10 +106,20 @@ func (l *LogWatcher) Init(logFile string, filterLines bool,
 
   l.readOffset = -1
   l.lineBuffer = make([]byte, 4096)[:0]
+  l.commands = make(chan int)
   l.errors = make(chan error, 5)
+  l.logger = discardLogger()
+  l.stats = WatchStats{Source: source, File: logFile}
   return nil
 }
 
+// SetLogger makes the watcher log its diagnostics.
+//
+// The records are tagged with the watcher's source name.
+func (l *LogWatcher) SetLogger(logger *slog.Logger) {
+  l.logger = logger.With("source", l.source)
+}
+
 // Errors returns the channel for errors encountered while watching the log.
 func (l *LogWatcher) Errors() <-chan error {
   return l.errors
@@ -61,27 +135,211 @@ func (l *LogWatcher) ReportExistingData() {
   }
 }
 
+// UseCheckpoint configures the watcher to persist its progress in a file.
+//
+// When the watcher starts, it resumes reading the log where the checkpoint
+// says that the previous watcher stopped, as long as the log file was not
+// truncated or replaced in the meantime. Otherwise, the watcher starts at
+// the end of the file, or at its beginning if ReportExistingData was called.
+//
+// The checkpoint only covers the lines that the uploader has spooled, so a
+// reporter that dies before spooling some lines reports them again when it
+// restarts.
+func (l *LogWatcher) UseCheckpoint(stateFile string) {
+  l.checkpointFile = stateFile
+  l.checkpointOffset = -1
+}
+
+// Stats returns a summary of the lines read so far.
+//
+// It can be called while the watcher runs.
+func (l *LogWatcher) Stats() WatchStats {
+  l.statsMutex.Lock()
+  defer l.statsMutex.Unlock()
+  return l.stats
+}
+
+// Resumed returns true if the watcher resumed reading from its checkpoint.
+func (l *LogWatcher) Resumed() bool {
+  return l.resumed
+}
+
 // Start spawns a goroutine that listens for log-related filesystem events.
 func (l *LogWatcher) Start() error {
+  if err := l.resumeFromCheckpoint(); err != nil {
+    return err
+  }
+  l.baseOffset = l.readOffset
   if err := l.handleWrite(); err != nil {
     return err
   }
   if err := l.fsWatcher.Add(l.logFile); err != nil {
     return err
   }
+  l.logger.Info("Watching log", "file", l.logFile, "offset", l.readOffset,
+      "resumed", l.resumed)
+  l.listening = true
   go l.listenLoop()
   return nil
 }
 
 // Stop causes the filesystem listener to break out of its loop.
+//
+// If the watcher uses a checkpoint, Stop waits for the reported lines to be
+// spooled, then saves the checkpoint. The lines must keep flowing from the
+// watcher's channel to the uploader until Stop returns. The watcher cannot
+// be restarted after it stops.
 func (l *LogWatcher) Stop() error {
-  if err := l.fsWatcher.Remove(l.logFile); err != nil {
+  if l.listening {
+    l.commands <- 1
+    l.listening = false
+  }
+  err := l.saveFinalCheckpoint()
+  if closeErr := l.fsWatcher.Close(); err == nil {
+    err = closeErr
+  }
+  if l.log != nil {
+    if closeErr := l.log.Close(); err == nil {
+      err = closeErr
+    }
+    l.log = nil
+  }
+  l.logger.Debug("Stopped watching log", "offset", l.readOffset)
+  return err
+}
+
+// resumeFromCheckpoint starts reading the log where the checkpoint says.
+//
+// It does nothing if the checkpoint doesn't match the log file.
+func (l *LogWatcher) resumeFromCheckpoint() error {
+  if l.checkpointFile == "" {
+    return nil
+  }
+  checkpoint, err := ReadLogCheckpoint(l.checkpointFile)
+  if err != nil || checkpoint == nil {
+    return err
+  }
+
+  file, err := os.Open(l.logFile)
+  if os.IsNotExist(err) {
+    return nil
+  }
+  if err != nil {
     return err
   }
-  l.commands <- 1
+  defer file.Close()
+  matches, err := checkpoint.Matches(file)
+  if err != nil {
+    return err
+  }
+  if matches {
+    l.readOffset = checkpoint.Offset
+    l.checkpointOffset = checkpoint.Offset
+    l.resumed = true
+  }
   return nil
 }
 
+// saveCheckpoint records the watcher's progress in its state file.
+//
+// The checkpoint covers the lines that were spooled.
+func (l *LogWatcher) saveCheckpoint() error {
+  if l.checkpointFile == "" {
+    return nil
+  }
+  spooledOffset := l.spooledLogOffset()
+  if spooledOffset == l.checkpointOffset {
+    return nil
+  }
+  checkpoint, err := TakeLogCheckpoint(l.log, spooledOffset)
+  if err != nil {
+    return err
+  }
+  if err := checkpoint.Write(l.checkpointFile); err != nil {
+    return err
+  }
+  l.checkpointOffset = spooledOffset
+  return nil
+}
+
+// saveFinalCheckpoint records the progress of a watcher that is stopping.
+//
+// It waits for the reported lines to be spooled first.
+func (l *LogWatcher) saveFinalCheckpoint() error {
+  if l.checkpointFile == "" || l.readOffset == -1 {
+    return nil
+  }
+  l.waitForSpooling()
+  if l.log == nil {
+    // NOTE: On Windows, the log file is only open while it is being read.
+    var err error
+    l.log, err = os.OpenFile(l.logFile, os.O_RDONLY, 0644)
+    if os.IsNotExist(err) {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+  }
+  return l.saveCheckpoint()
+}
+
+// spooledLogOffset returns the log offset right after the spooled lines.
+//
+// The lines in the log file after the offset were not spooled yet, or were
+// rejected by the watcher's filter.
+func (l *LogWatcher) spooledLogOffset() int64 {
+  l.spoolMutex.Lock()
+  defer l.spoolMutex.Unlock()
+  if l.spooledLines == l.sentLines {
+    // NOTE: The bytes in the line buffer belong to an incomplete line, which
+    //       hasn't been reported yet.
+    return l.readOffset - int64(len(l.lineBuffer))
+  }
+  if l.spooledLines <= l.baseLines {
+    return l.baseOffset
+  }
+  return l.spooledOffset
+}
+
+// lineSpooled records that the uploader spooled a line reported by the
+// watcher.
+//
+// It is called on the uploader's goroutine.
+func (l *LogWatcher) lineSpooled(sequence int64, endOffset int64) {
+  l.spoolMutex.Lock()
+  defer l.spoolMutex.Unlock()
+  l.spooledLines = sequence
+  l.spooledOffset = endOffset
+  if l.spoolDrained != nil && l.spooledLines == l.sentLines {
+    close(l.spoolDrained)
+    l.spoolDrained = nil
+  }
+}
+
+// waitForSpooling waits until all the reported lines are spooled.
+//
+// It gives up after spoolDrainTimeout, so a stalled uploader can't block
+// Stop forever.
+func (l *LogWatcher) waitForSpooling() {
+  l.spoolMutex.Lock()
+  if l.spooledLines == l.sentLines {
+    l.spoolMutex.Unlock()
+    return
+  }
+  spoolDrained := make(chan struct{})
+  l.spoolDrained = spoolDrained
+  l.spoolMutex.Unlock()
+
+  timer := time.NewTimer(spoolDrainTimeout)
+  defer timer.Stop()
+  select {
+  case <- spoolDrained:
+  case <- timer.C:
+    l.logger.Warn("Stopped waiting for log lines to be spooled")
+  }
+}
+
 // tailLog reads the newly appended data from the log file.
 func (l *LogWatcher) tailLog() error {
   fileInfo, err := l.log.Stat()
@@ -92,12 +350,24 @@ func (l *LogWatcher) tailLog() error {
   logSize := fileInfo.Size()
   if logSize < l.readOffset {
     // The log file was truncated.
+    l.logger.Info("Log file truncated", "offset", l.readOffset,
+        "size", logSize)
     l.readOffset = 0
+    l.lineBuffer = l.lineBuffer[:0]
+    l.gap = true
+    l.baseLines = l.sentLines
+    l.baseOffset = 0
   } else if l.readOffset == -1 {
     // The watcher is just getting started.
     l.readOffset = logSize
+    l.gap = logSize > 0
+    l.baseOffset = logSize
   }
 
+  if l.readOffset < logSize {
+    l.logger.Debug("Reading log data", "offset", l.readOffset,
+        "bytes", logSize - l.readOffset)
+  }
   for l.readOffset < logSize {
     readSize := logSize - l.readOffset
     bufferOffset := len(l.lineBuffer)
@@ -116,12 +386,18 @@ func (l *LogWatcher) tailLog() error {
 
     l.sliceLines(bufferOffset)
   }
-  return nil
+  l.statsMutex.Lock()
+  l.stats.Offset = l.readOffset - int64(len(l.lineBuffer))
+  l.statsMutex.Unlock()
+  return l.saveCheckpoint()
 }
 
 // sliceLines removes complete lines from the read buffer.
 // "bufferOffset
 func (l *LogWatcher) sliceLines(bufferOffset int) {
+  // The read buffer holds the bytes right before the read offset.
+  bufferFileOffset := l.readOffset - int64(len(l.lineBuffer))
+  readTime := time.Now()
   lineStart := 0
   for {
     readBuffer := l.lineBuffer[bufferOffset:]
@@ -130,7 +406,8 @@ func (l *LogWatcher) sliceLines(bufferOffset int) {
       break
     }
     newlineIndex := relativeIndex + bufferOffset
-    l.reportLine(l.lineBuffer[lineStart : newlineIndex + 1])
+    l.reportLine(l.lineBuffer[lineStart : newlineIndex + 1],
+        bufferFileOffset + int64(lineStart), readTime)
 
     bufferOffset = newlineIndex + 1
     lineStart = bufferOffset
@@ -143,3 +420,59 @@ func (l *LogWatcher) sliceLines(bufferOffset int) {
   copy(l.lineBuffer[0:bufferOffset], l.lineBuffer[lineStart:])
   l.lineBuffer = l.lineBuffer[0:bufferOffset]
 }
+
+// acceptLine returns true if the watcher's filter accepts a line.
+//
+// The line includes its terminator, which is not passed to the filter. The
+// line is counted in the watcher's stats.
+func (l *LogWatcher) acceptLine(line []byte) bool {
+  accepted := l.filterLine(line)
+  l.statsMutex.Lock()
+  l.stats.LinesRead += 1
+  if !accepted {
+    l.stats.LinesFiltered += 1
+  }
+  l.statsMutex.Unlock()
+  return accepted
+}
+
+// filterLine implements acceptLine, without counting the line.
+func (l *LogWatcher) filterLine(line []byte) bool {
+  if l.filter == nil {
+    return true
+  }
+  line = bytes.TrimRight(line, "\r\n")
+  if len(l.linePrefix) > 0 {
+    line = append(append(make([]byte, 0, len(l.linePrefix) + len(line)),
+        l.linePrefix...), line...)
+  }
+  return l.filter.Accept(line)
+}
+
+// newLogLine wraps a line's contents with its source information.
+//
+// The offsets are the line's start and end positions in the log file. The
+// line is counted as reported.
+func (l *LogWatcher) newLogLine(data []byte, offset int64, endOffset int64,
+    readTime time.Time) LogLine {
+  if len(l.linePrefix) > 0 {
+    data = append(append(make([]byte, 0, len(l.linePrefix) + len(data)),
+        l.linePrefix...), data...)
+  }
+  gap := l.gap
+  l.gap = false
+  l.spoolMutex.Lock()
+  l.sentLines += 1
+  sequence := l.sentLines
+  l.spoolMutex.Unlock()
+  return LogLine{
+    Source: l.source,
+    Offset: offset,
+    Time: readTime,
+    Data: data,
+    gap: gap,
+    watcher: l,
+    sequence: sequence,
+    endOffset: endOffset,
+  }
+}
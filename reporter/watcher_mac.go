@@ -3,22 +3,37 @@
 package reporter
 
 import (
+  "fmt"
   "os"
+  "syscall"
+  "time"
 )
 
 // listenLoop repeatedly listens for filesystem events and acts on them.
 func (l *LogWatcher) listenLoop() {
+  // The checkpoint only covers the spooled lines, so it must be saved again
+  // after the uploader catches up, even if the log is not written to.
+  checkpointTicker := time.NewTicker(checkpointInterval)
+  defer checkpointTicker.Stop()
+
   for {
     select {
     case <- l.fsWatcher.Events:
       if err := l.handleWrite(); err != nil {
         l.errors <- err
       }
+    case <- checkpointTicker.C:
+      if l.log == nil {
+        continue
+      }
+      if err := l.saveCheckpoint(); err != nil {
+        l.errors <- err
+      }
     case fsError := <- l.fsWatcher.Errors:
       l.errors <- fsError
     case command := <- l.commands:
       if command == 1 {
-        break
+        return
       }
     }
   }
@@ -38,9 +53,11 @@ func (l *LogWatcher) handleWrite() error {
 }
 
 // reportLine sends the line information over the channel.
-func (l *LogWatcher) reportLine(line []byte) {
-  if len(line) == 0 || (l.filterLines && line[0] != byte('[')) {
-    // Skip lines that don't start with a [
+//
+// The offset is the line's position in the log file.
+func (l *LogWatcher) reportLine(line []byte, offset int64,
+    readTime time.Time) {
+  if len(line) == 0 || !l.acceptLine(line) {
     return
   }
 
@@ -49,5 +66,17 @@ func (l *LogWatcher) reportLine(line []byte) {
   // TODO(pwnall): Consider cutting slices from large pools.
   lineCopy := make([]byte, len(line))
   copy(lineCopy, line)
-  l.logLines <- lineCopy
+  l.logLines <- l.newLogLine(lineCopy, offset,
+      offset + int64(len(line)), readTime)
+}
+
+// fileId returns a string that identifies the file across renames.
+//
+// The identifier consists of the file's device and inode numbers.
+func fileId(file *os.File, fileInfo os.FileInfo) string {
+  stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+  if !ok {
+    return ""
+  }
+  return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino)
 }
@@ -0,0 +1,311 @@
+package reporter
+
+import (
+  "fmt"
+  "log/slog"
+)
+
+// Watcher is a source of log lines that can be managed by a WatcherSet.
+//
+// LogWatcher and LogDirWatcher implement this interface.
+type Watcher interface {
+  // Start begins reporting log lines.
+  Start() error
+  // Stop stops reporting log lines. It is only called after Start succeeds.
+  Stop() error
+  // Errors returns the channel for errors encountered while watching.
+  Errors() <-chan error
+  // ReportExistingData configures the watcher to report the log data that
+  // exists when it starts. It is only called before Start.
+  ReportExistingData()
+}
+
+// ExistingDataPolicy says whether a source's existing log data is reported.
+type ExistingDataPolicy int
+
+const (
+  // The existing data is reported if the server asks for it.
+  ExistingDataIfRequested ExistingDataPolicy = iota
+  // The existing data is always reported.
+  ExistingDataAlways
+  // The existing data is never reported.
+  ExistingDataNever
+)
+
+// SourceConfig describes a log file that is watched by a WatcherSet.
+type SourceConfig struct {
+  // The name that identifies the source, such as "game".
+  //
+  // The name is attached to every line read from the file.
+  Name string
+  // Path to the log file.
+  Path string
+  // Decides which lines are reported. nil reports all lines.
+  Filter LineFilter
+  // Says whether the data in the file when watching starts is reported.
+  ExistingData ExistingDataPolicy
+  // The names of the sources that must be started before this source.
+  After []string
+  // Path to the state file holding the watcher's checkpoint. If empty, the
+  // watcher does not persist its progress.
+  CheckpointFile string
+}
+
+// WatchError is reported when a source in a WatcherSet encounters an error.
+type WatchError struct {
+  // The name of the source that encountered the error.
+  Source string
+  // The error encountered by the source's watcher.
+  Err error
+}
+
+func (e *WatchError) Error() string {
+  return fmt.Sprintf("%s log: %v", e.Source, e.Err)
+}
+
+// WatcherSet manages the watchers for all the logs that the reporter reads.
+//
+// The watchers are started together, in an order that respects the sources'
+// dependencies, and are stopped together, in the reverse order. Their errors
+// are merged into a single channel.
+type WatcherSet struct {
+  // Sink for the lines read by the watchers.
+  logLines chan<- LogLine
+  // The sources, in the order in which they were added.
+  sources []*watchedSource
+  // The sources, in the order in which they were started.
+  started []*watchedSource
+  // Sink for the errors encountered by all the watchers.
+  errors chan error
+  // Closed to stop forwarding the watchers' errors.
+  done chan struct{}
+  // Receives the diagnostics of the watchers created by AddFile.
+  logger *slog.Logger
+}
+
+// watchedSource is a source in a WatcherSet.
+type watchedSource struct {
+  // The name that identifies the source.
+  name string
+  // Produces the source's log lines.
+  watcher Watcher
+  // Says whether the watcher's existing data is reported.
+  existingData ExistingDataPolicy
+  // The names of the sources that must be started before this source.
+  after []string
+}
+
+// Init sets up the watcher set's initial state.
+//
+// The watchers for the sources added with AddFile send their lines to
+// logLines. Watchers added with Add should use the same channel.
+func (w *WatcherSet) Init(logLines chan<- LogLine) {
+  w.logLines = logLines
+  w.sources = nil
+  w.started = nil
+  w.errors = make(chan error, 5)
+  w.done = make(chan struct{})
+  w.logger = discardLogger()
+}
+
+// SetLogger makes the watchers created by AddFile log their diagnostics.
+//
+// It only applies to the sources added after it is called.
+func (w *WatcherSet) SetLogger(logger *slog.Logger) {
+  w.logger = logger
+}
+
+// LogLines returns the channel that the set's watchers send lines to.
+func (w *WatcherSet) LogLines() chan<- LogLine {
+  return w.logLines
+}
+
+// Errors returns the channel that receives all the watchers' errors.
+//
+// The errors are wrapped in WatchError values.
+func (w *WatcherSet) Errors() <-chan error {
+  return w.errors
+}
+
+// AddFile adds a source that reads a log file.
+//
+// It returns any error encountered.
+func (w *WatcherSet) AddFile(config SourceConfig) error {
+  watcher := &LogWatcher{}
+  err := watcher.Init(config.Name, config.Path, config.Filter, w.logLines)
+  if err != nil {
+    return err
+  }
+  watcher.SetLogger(w.logger)
+  if config.CheckpointFile != "" {
+    watcher.UseCheckpoint(config.CheckpointFile)
+  }
+  return w.Add(config.Name, watcher, config.ExistingData, config.After)
+}
+
+// Add adds a source whose lines are produced by a custom watcher.
+//
+// It returns an error if the name is already used by another source. The
+// after argument lists the sources that must be started before this source.
+func (w *WatcherSet) Add(name string, watcher Watcher,
+    existingData ExistingDataPolicy, after []string) error {
+  if w.Watcher(name) != nil {
+    return fmt.Errorf("Duplicate log source: %s", name)
+  }
+  w.sources = append(w.sources, &watchedSource{
+    name: name,
+    watcher: watcher,
+    existingData: existingData,
+    after: after,
+  })
+  return nil
+}
+
+// Watcher returns the watcher for a source, or nil if the source is missing.
+func (w *WatcherSet) Watcher(name string) Watcher {
+  for _, source := range w.sources {
+    if source.name == name {
+      return source.watcher
+    }
+  }
+  return nil
+}
+
+// Names returns the names of the sources, in the order they were added.
+func (w *WatcherSet) Names() []string {
+  names := make([]string, len(w.sources))
+  for i, source := range w.sources {
+    names[i] = source.name
+  }
+  return names
+}
+
+// Resumed returns the names of the sources that resumed from a checkpoint.
+func (w *WatcherSet) Resumed() []string {
+  var names []string
+  for _, source := range w.started {
+    if resumer, ok := source.watcher.(interface{ Resumed() bool }); ok &&
+        resumer.Resumed() {
+      names = append(names, source.name)
+    }
+  }
+  return names
+}
+
+// Stats returns a summary of the lines read by each watcher.
+//
+// Custom watchers added with Add are not included. It can be called while the
+// watchers run.
+func (w *WatcherSet) Stats() []WatchStats {
+  var stats []WatchStats
+  for _, source := range w.sources {
+    switch watcher := source.watcher.(type) {
+    case *LogWatcher:
+      stats = append(stats, watcher.Stats())
+    case *LogDirWatcher:
+      stats = append(stats, watcher.Stats()...)
+    }
+  }
+  return stats
+}
+
+// Start starts all the watchers.
+//
+// It returns any error encountered. If a watcher fails to start, the
+// watchers that were already started are stopped. The existingData argument
+// is true if the server asked for the data that already exists in the logs.
+func (w *WatcherSet) Start(existingData bool) error {
+  order, err := w.startOrder()
+  if err != nil {
+    return err
+  }
+
+  for _, source := range order {
+    if source.existingData == ExistingDataAlways ||
+        (source.existingData == ExistingDataIfRequested && existingData) {
+      source.watcher.ReportExistingData()
+    }
+    if err := source.watcher.Start(); err != nil {
+      w.Stop()
+      return &WatchError{Source: source.name, Err: err}
+    }
+    w.started = append(w.started, source)
+    go w.forwardErrors(source)
+  }
+  return nil
+}
+
+// Stop stops all the started watchers, in the reverse of the start order.
+//
+// It returns the first error encountered. All the watchers are stopped, even
+// if some of them fail to stop cleanly.
+func (w *WatcherSet) Stop() error {
+  var err error
+  for i := len(w.started) - 1; i >= 0; i -= 1 {
+    source := w.started[i]
+    if stopErr := source.watcher.Stop(); stopErr != nil && err == nil {
+      err = &WatchError{Source: source.name, Err: stopErr}
+    }
+  }
+  w.started = nil
+  close(w.done)
+  w.done = make(chan struct{})
+  return err
+}
+
+// forwardErrors sends a watcher's errors to the set's error channel.
+func (w *WatcherSet) forwardErrors(source *watchedSource) {
+  errors := source.watcher.Errors()
+  done := w.done
+  for {
+    select {
+    case err := <- errors:
+      // NOTE: The caller may stop reading errors after Stop, so the send
+      //       must not block the goroutine forever.
+      select {
+      case w.errors <- &WatchError{Source: source.name, Err: err}:
+      case <- done:
+        return
+      }
+    case <- done:
+      return
+    }
+  }
+}
+
+// startOrder sorts the sources so that each source follows its dependencies.
+//
+// Sources that don't depend on each other keep the order in which they were
+// added. It returns an error if a dependency is missing or circular.
+func (w *WatcherSet) startOrder() ([]*watchedSource, error) {
+  var order []*watchedSource
+  placed := make(map[string]bool)
+  for len(order) < len(w.sources) {
+    progress := false
+    for _, source := range w.sources {
+      if placed[source.name] {
+        continue
+      }
+      ready := true
+      for _, dependency := range source.after {
+        if w.Watcher(dependency) == nil {
+          return nil, fmt.Errorf("Log source %s depends on missing source %s",
+              source.name, dependency)
+        }
+        if !placed[dependency] {
+          ready = false
+          break
+        }
+      }
+      if ready {
+        order = append(order, source)
+        placed[source.name] = true
+        progress = true
+      }
+    }
+    if !progress {
+      return nil, fmt.Errorf("Circular dependencies between log sources")
+    }
+  }
+  return order, nil
+}
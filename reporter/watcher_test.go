@@ -0,0 +1,122 @@
+package reporter
+
+import (
+  "io/ioutil"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+)
+
+// receiveTestLines reads the lines that a watcher already reported.
+func receiveTestLines(logLines <-chan LogLine) []LogLine {
+  var lines []LogLine
+  for {
+    select {
+    case line := <- logLines:
+      lines = append(lines, line)
+    default:
+      return lines
+    }
+  }
+}
+
+// lineData returns the contents of log lines.
+func lineData(lines []LogLine) []string {
+  data := make([]string, len(lines))
+  for i, line := range lines {
+    data[i] = string(line.Data)
+  }
+  return data
+}
+
+func TestLogWatcherResumesAfterUnspooledLines(t *testing.T) {
+  logData := []string{
+    "[Power] line 1\n",
+    "[Power] line 2\n",
+    "[Power] line 3\n",
+    "[Power] line 4\n",
+  }
+  dir := t.TempDir()
+  logFile := filepath.Join(dir, "output_log.txt")
+  err := ioutil.WriteFile(logFile, []byte(strings.Join(logData, "")), 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  checkpointFile := filepath.Join(dir, "game-log.checkpoint")
+
+  logLines := make(chan LogLine, 16)
+  watcher := &LogWatcher{}
+  if err := watcher.Init("game", logFile, nil, logLines); err != nil {
+    t.Fatal(err)
+  }
+  watcher.UseCheckpoint(checkpointFile)
+  watcher.ReportExistingData()
+  if err := watcher.Start(); err != nil {
+    t.Fatalf("Start: %v", err)
+  }
+  lines := receiveTestLines(logLines)
+  if !reflect.DeepEqual(lineData(lines), logData) {
+    t.Fatalf("first run got %q, want %q", lineData(lines), logData)
+  }
+  // Only the first two lines make it to the spool.
+  lines[0].markSpooled()
+  lines[1].markSpooled()
+  spooledOffset := int64(len(logData[0]) + len(logData[1]))
+  deadline := time.Now().Add(5 * checkpointInterval)
+  for {
+    checkpoint, err := ReadLogCheckpoint(checkpointFile)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if checkpoint != nil && checkpoint.Offset == spooledOffset {
+      break
+    }
+    if checkpoint != nil && checkpoint.Offset > spooledOffset {
+      t.Fatalf("checkpoint offset %d covers lines that weren't spooled",
+          checkpoint.Offset)
+    }
+    if time.Now().After(deadline) {
+      t.Fatalf("checkpoint was not saved after lines were spooled: %+v",
+          checkpoint)
+    }
+    time.Sleep(checkpointInterval / 10)
+  }
+
+  // The reporter dies before spooling the other lines, so Stop doesn't get
+  // to save the final checkpoint.
+  watcher.commands <- 1
+  watcher.fsWatcher.Close()
+  watcher.log.Close()
+
+  logLines = make(chan LogLine, 16)
+  watcher = &LogWatcher{}
+  if err := watcher.Init("game", logFile, nil, logLines); err != nil {
+    t.Fatal(err)
+  }
+  watcher.UseCheckpoint(checkpointFile)
+  if err := watcher.Start(); err != nil {
+    t.Fatalf("Start after restart: %v", err)
+  }
+  lines = receiveTestLines(logLines)
+  if want := logData[2:]; !reflect.DeepEqual(lineData(lines), want) {
+    t.Errorf("second run got %q, want %q", lineData(lines), want)
+  }
+  if !watcher.Resumed() {
+    t.Errorf("second run did not resume from the checkpoint")
+  }
+
+  for i := range lines {
+    lines[i].markSpooled()
+  }
+  if err := watcher.Stop(); err != nil {
+    t.Fatalf("Stop: %v", err)
+  }
+  checkpoint, err := ReadLogCheckpoint(checkpointFile)
+  if err != nil || checkpoint == nil ||
+      checkpoint.Offset != int64(len(strings.Join(logData, ""))) {
+    t.Errorf("checkpoint after Stop is %+v %v, want the end of the log",
+        checkpoint, err)
+  }
+}
@@ -3,7 +3,9 @@
 package reporter
 
 import (
+  "fmt"
   "os"
+  "syscall"
   "time"
 )
 
@@ -15,7 +17,9 @@ func (l *LogWatcher) listenLoop() {
   //
   // This problem is confirmed by another Hearthstone tracker project.
   // https://github.com/stevschmid/track-o-bot/blob/master/src/HearthstoneLogWatcher.cpp
-  pollingTicks := time.NewTicker(time.Millisecond * 500).C
+  pollingTicker := time.NewTicker(time.Millisecond * 500)
+  defer pollingTicker.Stop()
+  pollingTicks := pollingTicker.C
 
   for {
     select {
@@ -31,7 +35,7 @@ func (l *LogWatcher) listenLoop() {
       l.errors <- fsError
     case command := <- l.commands:
       if command == 1 {
-        break
+        return
       }
     }
   }
@@ -60,10 +64,12 @@ func (l *LogWatcher) handleWrite() error {
 }
 
 // reportLine sends the line information over the channel.
-func (l *LogWatcher) reportLine(line []byte) {
-  // Skip lines that don't start with a [. All lines must end in a newline, so
-  // they should be at least 2 bytes long.
-  if len(line) < 2 || (l.filterLines && line[0] != byte('[')) {
+//
+// The offset is the line's position in the log file.
+func (l *LogWatcher) reportLine(line []byte, offset int64,
+    readTime time.Time) {
+  // All lines must end in a newline, so they should be at least 2 bytes long.
+  if len(line) < 2 || !l.acceptLine(line) {
     return
   }
 
@@ -82,5 +88,19 @@ func (l *LogWatcher) reportLine(line []byte) {
     lineCopy = make([]byte, len(line))
     copy(lineCopy, line)
   }
-  l.logLines <- lineCopy
+  l.logLines <- l.newLogLine(lineCopy, offset,
+      offset + int64(len(line)), readTime)
+}
+
+// fileId returns a string that identifies the file across renames.
+//
+// The identifier consists of the volume serial number and the NTFS file index.
+func fileId(file *os.File, fileInfo os.FileInfo) string {
+  var info syscall.ByHandleFileInformation
+  err := syscall.GetFileInformationByHandle(syscall.Handle(file.Fd()), &info)
+  if err != nil {
+    return ""
+  }
+  return fmt.Sprintf("%x:%x%08x", info.VolumeSerialNumber,
+      info.FileIndexHigh, info.FileIndexLow)
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "github.com/pwnall/hsreporter/reporter"
+  "io/ioutil"
+  "os"
+)
+
+// restoreConfigMain implements the restore-config command.
+//
+// The command puts back Hearthstone's logging config from before hsreporter
+// changed it, after showing the changes that it undoes.
+func restoreConfigMain(args []string) {
+  flags := flag.NewFlagSet("restore-config", flag.ExitOnError)
+  configFile := flags.String("log-config", reporter.DefaultConfigFile(),
+      "Path to Hearthstone's logging configuration file")
+  stateDir := flags.String("state-dir", reporter.DefaultStateDir(),
+      "Directory for the reporter's state, such as not yet uploaded logs")
+  dryRun := flags.Bool("dry-run", false,
+      "Show the changes made by hsreporter without undoing them")
+  flags.Parse(args)
+
+  backupFile := reporter.ConfigBackupFile(*stateDir)
+  backup, err := reporter.ReadConfigBackup(backupFile)
+  if err != nil {
+    fmt.Println(err)
+    os.Exit(1)
+  }
+  if backup == nil {
+    fmt.Printf("No backup of %s found in %s\n", *configFile, *stateDir)
+    return
+  }
+
+  data, err := ioutil.ReadFile(*configFile)
+  if err != nil && !os.IsNotExist(err) {
+    fmt.Println(err)
+    os.Exit(1)
+  }
+  diff := reporter.DiffLines(backup.Contents, string(data))
+  if diff == "" && backup.Existed == (err == nil) {
+    fmt.Printf("%s has no changes made by hsreporter\n", *configFile)
+  } else {
+    fmt.Printf("Changes made by hsreporter to %s:\n%s", *configFile, diff)
+  }
+  if *dryRun {
+    return
+  }
+
+  if _, err := reporter.RestoreConfigFile(*configFile, backupFile);
+      err != nil {
+    fmt.Println(err)
+    os.Exit(1)
+  }
+  if backup.Existed {
+    fmt.Printf("Restored %s\n", *configFile)
+  } else {
+    fmt.Printf("Removed %s, which did not exist before hsreporter\n",
+        *configFile)
+  }
+  fmt.Println("Restart Hearthstone to pick up the restored logging config.")
+}
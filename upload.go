@@ -0,0 +1,87 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "github.com/pwnall/hsreporter/reporter"
+  "os"
+  "time"
+)
+
+// The time between checks for the end of a backfill upload.
+const uploadPollInterval = 250 * time.Millisecond
+
+// uploadMain implements the upload command.
+//
+// The command uploads old game log files, such as the logs in the game
+// archive, and waits until the server accepts all their lines.
+func uploadMain(args []string) {
+  var uploader reporter.State
+  flags := flag.NewFlagSet("upload", flag.ExitOnError)
+  addUploadFlags(flags, &uploader.Config)
+  backfill := flags.Bool("backfill", true,
+      "Tell the server that the uploaded logs may have been uploaded before")
+  var logFlags logOptions
+  addLogFlags(flags, &logFlags)
+  flags.Usage = func() {
+    fmt.Fprintf(flags.Output(), "Usage: %s upload [flags] FILE...\n",
+        os.Args[0])
+    flags.PrintDefaults()
+  }
+  flags.Parse(args)
+  if flags.NArg() == 0 {
+    flags.Usage()
+    os.Exit(2)
+  }
+
+  var err error
+  if uploader.Config.Logger, err = openLog(logFlags); err != nil {
+    fmt.Println(err)
+    os.Exit(1)
+  }
+  if err := uploader.InitBackfill(*backfill); err != nil {
+    fmt.Println(err)
+    os.Exit(1)
+  }
+  if pending := uploader.Spool.PendingBytes(); pending > 0 {
+    fmt.Printf("Resuming a previous upload with %d bytes left\n", pending)
+  }
+  for _, path := range flags.Args() {
+    lines, err := uploader.BackfillFile(path)
+    if err != nil {
+      fmt.Printf("%s: %v\n", path, err)
+      os.Exit(1)
+    }
+    fmt.Printf("%s: %d lines queued\n", path, lines)
+    if lines == 0 {
+      fmt.Printf("%s: No lines matched the server's categories and the " +
+          "filters\n", path)
+    }
+  }
+
+  if err := uploader.Uploader.Start(); err != nil {
+    fmt.Println(err)
+    os.Exit(1)
+  }
+  uploadErrors := uploader.Uploader.Errors()
+  ticker := time.NewTicker(uploadPollInterval)
+  defer ticker.Stop()
+  for uploader.Spool.PendingBytes() > 0 {
+    select {
+    case uploadErr := <- uploadErrors:
+      fmt.Printf("Upload error: %v\n", uploadErr)
+      if err, ok := uploadErr.(*reporter.UploadError); ok && err.Fatal {
+        fmt.Println("Get a new token from your analytics application.")
+        os.Exit(1)
+      }
+    case <- ticker.C:
+    }
+  }
+  if err := uploader.Spool.Close(); err != nil {
+    fmt.Println(err)
+    os.Exit(1)
+  }
+  stats := uploader.Uploader.Stats()
+  fmt.Printf("Uploaded %d bytes in %d requests\n", stats.RawBytes,
+      stats.Batches)
+}